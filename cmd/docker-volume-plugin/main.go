@@ -9,7 +9,6 @@ import (
 	"strings"
 
 	"github.com/docker/go-connections/sockets"
-	"github.com/docker/go-plugins-helpers/volume"
 )
 
 func main() {
@@ -54,7 +53,7 @@ func main() {
 	}
 	defer listener.Close()
 
-	handler := volume.NewHandler(driverAdapter)
+	handler := adapters.NewHandler(driverAdapter)
 	err = handler.Serve(listener)
 	if err != nil {
 		logger.Fatalf("failed to serve volume handler: %v", err)
@@ -5,13 +5,20 @@ import (
 	"docker-volume-plugin/pkg/drivers"
 	"docker-volume-plugin/pkg/drivers/apis"
 	"docker-volume-plugin/pkg/log"
+	"fmt"
+	"net/http"
 	"path"
 	"strings"
 	"time"
 
+	"github.com/docker/go-plugins-helpers/sdk"
 	"github.com/docker/go-plugins-helpers/volume"
 )
 
+// ForceRemovePath is the endpoint of the plugin socket forcing the removal of a volume, which docker itself never
+// calls. It takes the request of Remove.
+const ForceRemovePath = "/VolumePlugin.ForceRemove"
+
 type VolumePlugin struct {
 	driverInstance apis.Driver
 	logger         *log.Logger
@@ -19,8 +26,32 @@ type VolumePlugin struct {
 	volume.Driver
 }
 
+// NewHandler serves plugin over the docker volume plugin protocol, with ForceRemove on ForceRemovePath
+func NewHandler(plugin *VolumePlugin) *volume.Handler {
+	handler := volume.NewHandler(plugin)
+	handler.HandleFunc(ForceRemovePath, func(w http.ResponseWriter, r *http.Request) {
+		req := &volume.RemoveRequest{}
+		err := sdk.DecodeRequest(w, r, req)
+		if err != nil {
+			return
+		}
+		err = plugin.ForceRemove(req)
+		if err != nil {
+			sdk.EncodeResponse(w, volume.NewErrorResponse(err.Error()), true)
+			return
+		}
+		sdk.EncodeResponse(w, struct{}{}, false)
+	})
+	return handler
+}
+
 func NewVolumePlugin(ctx context.Context, logger *log.Logger, driver string, driverOptions string) (*VolumePlugin, error) {
-	driverInstance, err := drivers.New(ctx, logger.WithService("nfs"), driver, volume.DefaultDockerRootDirectory, driverOptions)
+	return newVolumePlugin(ctx, logger, driver, volume.DefaultDockerRootDirectory, driverOptions)
+}
+
+// newVolumePlugin creates a volume plugin whose driver is mounted on mountpointBase
+func newVolumePlugin(ctx context.Context, logger *log.Logger, driver string, mountpointBase string, driverOptions string) (*VolumePlugin, error) {
+	driverInstance, err := drivers.New(ctx, logger.WithService("nfs"), driver, mountpointBase, driverOptions)
 	if err != nil {
 		return nil, err
 	}
@@ -28,7 +59,7 @@ func NewVolumePlugin(ctx context.Context, logger *log.Logger, driver string, dri
 	return &VolumePlugin{
 		driverInstance: driverInstance,
 		logger:         logger,
-		mountpointBase: volume.DefaultDockerRootDirectory,
+		mountpointBase: mountpointBase,
 	}, nil
 }
 
@@ -53,14 +84,13 @@ func (d *VolumePlugin) List() (*volume.ListResponse, error) {
 		return listResponse, err
 	}
 
+	backendStatus := d.driverInstance.Status()
 	for name, metadata := range volumeMetadataMap {
 		listResponse.Volumes = append(listResponse.Volumes, &volume.Volume{
 			Name:       name,
-			Mountpoint: path.Join(d.mountpointBase, metadata.Mountpoint),
+			Mountpoint: d.hostPath(metadata.Mountpoint),
 			CreatedAt:  metadata.CreatedAt.Local().Format(time.RFC3339),
-			Status: map[string]interface{}{
-				"mountBy": metadata.Status.MountBy,
-			},
+			Status:     volumeStatus(metadata, backendStatus),
 		})
 	}
 
@@ -80,11 +110,9 @@ func (d *VolumePlugin) Get(req *volume.GetRequest) (*volume.GetResponse, error)
 
 	getResponse.Volume = &volume.Volume{
 		Name:       req.Name,
-		Mountpoint: path.Join(d.mountpointBase, metadata.Mountpoint),
+		Mountpoint: d.hostPath(metadata.Mountpoint),
 		CreatedAt:  metadata.CreatedAt.Local().Format(time.RFC3339),
-		Status: map[string]interface{}{
-			"mountBy": metadata.Status.MountBy,
-		},
+		Status:     volumeStatus(metadata, d.driverInstance.Status()),
 	}
 
 	return getResponse, nil
@@ -94,6 +122,18 @@ func (d *VolumePlugin) Remove(req *volume.RemoveRequest) error {
 	return d.driverInstance.Remove(req.Name)
 }
 
+// ForceRemove removes a volume even when other nodes still claim mounts of it, for operators to clean up after nodes
+// that are gone for good
+func (d *VolumePlugin) ForceRemove(req *volume.RemoveRequest) error {
+	forceRemover, ok := d.driverInstance.(apis.ForceRemover)
+	if !ok {
+		return fmt.Errorf("driver does not support forced removal")
+	}
+
+	d.logger.Warningf("force removal of volume %s", req.Name)
+	return forceRemover.ForceRemove(req.Name)
+}
+
 func (d *VolumePlugin) Path(req *volume.PathRequest) (*volume.PathResponse, error) {
 	pathResponse := &volume.PathResponse{}
 
@@ -103,7 +143,7 @@ func (d *VolumePlugin) Path(req *volume.PathRequest) (*volume.PathResponse, erro
 		return pathResponse, err
 	}
 
-	pathResponse.Mountpoint = path.Join(d.mountpointBase, mountpoint)
+	pathResponse.Mountpoint = mountpoint
 
 	return pathResponse, nil
 }
@@ -116,7 +156,7 @@ func (d *VolumePlugin) Mount(req *volume.MountRequest) (*volume.MountResponse, e
 		d.logger.Errorf("failed to mount volume %s: %v", req.Name, err)
 		return mountResponse, err
 	}
-	mountResponse.Mountpoint = path.Join(d.mountpointBase, mountpoint)
+	mountResponse.Mountpoint = mountpoint
 
 	return mountResponse, nil
 }
@@ -136,3 +176,30 @@ func (d *VolumePlugin) Destroy() error {
 	}
 	return nil
 }
+
+// hostPath converts a mountpoint stored relative to the driver root to the absolute host path docker expects,
+// which is the root Path and Mount of the driver report their paths under.
+func (d *VolumePlugin) hostPath(mountpoint string) string {
+	return path.Join(d.mountpointBase, mountpoint)
+}
+
+// volumeStatus builds the status of a volume reported to docker
+func volumeStatus(metadata *apis.VolumeMetadata, backendStatus map[string]interface{}) map[string]interface{} {
+	status := map[string]interface{}{
+		"mountBy": metadata.Status.MountBy,
+		"backend": backendStatus,
+	}
+	if metadata.Status.MountNodes != nil {
+		status["mountNodes"] = metadata.Status.MountNodes
+	}
+	if metadata.Status.Warmup != nil {
+		status["warmup"] = metadata.Status.Warmup
+	}
+	if metadata.Status.Usage != nil {
+		status["usage"] = metadata.Status.Usage
+	}
+	if metadata.Status.Clone != nil {
+		status["clone"] = metadata.Status.Clone
+	}
+	return status
+}
@@ -0,0 +1,152 @@
+package adapters
+
+import (
+	"context"
+	"docker-volume-plugin/pkg/log"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"path"
+	"strings"
+	"testing"
+)
+
+// newTestPluginServer serves a mock nfs volume plugin under a temporary mountpoint over the docker plugin protocol
+func newTestPluginServer(t *testing.T) (string, string) {
+	mountpointBase := t.TempDir()
+	plugin, err := newVolumePlugin(context.Background(), log.New("test-adapter").WithLogLevel(log.WarnLevel), "nfs", mountpointBase, `{
+		"address": "nfs-server.mock",
+		"remotePath": "/mock"
+	}`)
+	if err != nil {
+		t.Fatalf("got error when new volume plugin: %v", err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("got error when listen: %v", err)
+	}
+	go NewHandler(plugin).Serve(listener)
+	t.Cleanup(func() {
+		listener.Close()
+		if err := plugin.Destroy(); err != nil {
+			t.Errorf("got error when destroy volume plugin: %v", err)
+		}
+	})
+
+	return "http://" + listener.Addr().String(), mountpointBase
+}
+
+// call posts body to the endpoint as the docker daemon does and returns the status code and raw response body
+func call(t *testing.T, url string, endpoint string, body string) (int, string) {
+	resp, err := http.Post(url+endpoint, "application/vnd.docker.plugins.v1.2+json", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("got error when call %s: %v", endpoint, err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("got error when read response of %s: %v", endpoint, err)
+	}
+	return resp.StatusCode, string(respBody)
+}
+
+func TestVolumePluginProtocol(t *testing.T) {
+	url, mountpointBase := newTestPluginServer(t)
+	hostPath := path.Join(mountpointBase, "test", "_data")
+
+	status, body := call(t, url, "/VolumeDriver.Create", `{"Name": "test", "Opts": {}}`)
+	if status != http.StatusOK {
+		t.Fatalf("expected create to succeed, got %d %s", status, body)
+	}
+
+	// Path of an unmounted volume is still the absolute host path
+	status, body = call(t, url, "/VolumeDriver.Path", `{"Name": "test"}`)
+	if status != http.StatusOK {
+		t.Fatalf("expected path to succeed, got %d %s", status, body)
+	}
+	pathResponse := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(body), &pathResponse); err != nil {
+		t.Fatalf("got error when parse path response %s: %v", body, err)
+	}
+	if pathResponse["Mountpoint"] != hostPath {
+		t.Errorf("expected path mountpoint %s, got %s", hostPath, body)
+	}
+
+	status, body = call(t, url, "/VolumeDriver.Get", `{"Name": "test"}`)
+	if status != http.StatusOK {
+		t.Fatalf("expected get to succeed, got %d %s", status, body)
+	}
+	getResponse := struct {
+		Volume map[string]interface{}
+	}{}
+	if err := json.Unmarshal([]byte(body), &getResponse); err != nil {
+		t.Fatalf("got error when parse get response %s: %v", body, err)
+	}
+	if getResponse.Volume["Name"] != "test" || getResponse.Volume["Mountpoint"] != hostPath {
+		t.Errorf("expected volume test at %s, got %s", hostPath, body)
+	}
+	if _, ok := getResponse.Volume["CreatedAt"].(string); !ok {
+		t.Errorf("expected createdAt in get response, got %s", body)
+	}
+
+	// Mount reports the bind mount of the container, under the same host root as Path and Get
+	status, body = call(t, url, "/VolumeDriver.Mount", `{"Name": "test", "ID": "1"}`)
+	if status != http.StatusOK {
+		t.Fatalf("expected mount to succeed, got %d %s", status, body)
+	}
+	mountResponse := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(body), &mountResponse); err != nil {
+		t.Fatalf("got error when parse mount response %s: %v", body, err)
+	}
+	if mountResponse["Mountpoint"] != path.Join(mountpointBase, "test", "_mounts", "1") {
+		t.Errorf("expected mount mountpoint %s, got %s", path.Join(mountpointBase, "test", "_mounts", "1"), body)
+	}
+
+	status, body = call(t, url, "/VolumeDriver.Unmount", `{"Name": "test", "ID": "1"}`)
+	if status != http.StatusOK {
+		t.Fatalf("expected unmount to succeed, got %d %s", status, body)
+	}
+
+	// Errors are reported in the Err field
+	for _, endpoint := range []string{"/VolumeDriver.Path", "/VolumeDriver.Get"} {
+		status, body = call(t, url, endpoint, `{"Name": "non-exist"}`)
+		errResponse := map[string]interface{}{}
+		if err := json.Unmarshal([]byte(body), &errResponse); err != nil {
+			t.Fatalf("got error when parse %s response %s: %v", endpoint, body, err)
+		}
+		if status != http.StatusInternalServerError || errResponse["Err"] == "" || errResponse["Err"] == nil {
+			t.Errorf("expected %s of non-exist volume to fail with Err, got %d %s", endpoint, status, body)
+		}
+	}
+}
+
+func TestVolumePluginForceRemove(t *testing.T) {
+	url, _ := newTestPluginServer(t)
+
+	status, body := call(t, url, "/VolumeDriver.Create", `{"Name": "test", "Opts": {}}`)
+	if status != http.StatusOK {
+		t.Fatalf("expected create to succeed, got %d %s", status, body)
+	}
+	status, body = call(t, url, "/VolumeDriver.Mount", `{"Name": "test", "ID": "1"}`)
+	if status != http.StatusOK {
+		t.Fatalf("expected mount to succeed, got %d %s", status, body)
+	}
+
+	status, body = call(t, url, "/VolumeDriver.Remove", `{"Name": "test"}`)
+	if status != http.StatusInternalServerError {
+		t.Errorf("expected remove of mounted volume to fail, got %d %s", status, body)
+	}
+
+	// Operators call the plugin socket directly to drop mounts that are never going to be unmounted
+	status, body = call(t, url, ForceRemovePath, `{"Name": "test"}`)
+	if status != http.StatusOK {
+		t.Fatalf("expected forced removal to succeed, got %d %s", status, body)
+	}
+	status, body = call(t, url, "/VolumeDriver.Get", `{"Name": "test"}`)
+	if status != http.StatusInternalServerError {
+		t.Errorf("expected get of removed volume to fail, got %d %s", status, body)
+	}
+}
@@ -0,0 +1,14 @@
+package apis
+
+import (
+	"errors"
+)
+
+var (
+	// ErrShuttingDown is returned when an operation arrives after the driver has been destroyed.
+	ErrShuttingDown = errors.New("driver shutting down")
+	// ErrBackendFull is returned when the backend storage has no space left.
+	ErrBackendFull = errors.New("backend storage is full")
+	// ErrVolumeNotFound is returned when an operation targets a volume that does not exist.
+	ErrVolumeNotFound = errors.New("volume not found")
+)
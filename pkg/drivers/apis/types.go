@@ -1,6 +1,7 @@
 package apis
 
 import (
+	"encoding/json"
 	"time"
 )
 
@@ -15,22 +16,99 @@ type Driver interface {
 	Get(name string) (*VolumeMetadata, error)
 	// Remove deletes a volume by name.
 	Remove(name string) error
-	// Path returns the mount point for a volume by name.
+	// Path returns the absolute mount point for a volume by name.
 	Path(name string) (string, error)
-	// Mount mounts a volume by name and ID.
+	// Mount mounts a volume by name and ID, and returns the absolute path to hand to the container.
 	Mount(name string, id string) (string, error)
 	// Unmount unmounts a volume by name and ID.
 	Unmount(name string, id string) error
+	// History returns the change history of a volume by name, oldest first.
+	History(name string) ([]*VolumeHistoryEntry, error)
+	// Status returns the runtime status of the driver backend.
+	Status() map[string]interface{}
 	// Destroy cleans up any resources used by the driver.
 	Destroy() error
 }
 
+// ForceRemover is implemented by drivers that can remove a volume still claimed by mounts, an escape hatch for
+// operators cleaning up after a node that is gone for good
+type ForceRemover interface {
+	// ForceRemove deletes a volume by name, dropping the claims of its mounts on every node.
+	ForceRemove(name string) error
+}
+
 type VolumeSpec struct {
 	PurgeAfterDelete bool `json:"purgeAfterDelete,omitempty"`
+	WarmupOnMount    bool `json:"warmupOnMount,omitempty"`
+	// ReadOnly volumes are handed to containers through a read-only bind mount
+	ReadOnly bool `json:"readOnly,omitempty"`
+	// SubPath of the volume data handed to containers instead of the whole data
+	SubPath string `json:"subPath,omitempty"`
+	// SizeBytes the volume data may use, 0 for no limit
+	SizeBytes uint64 `json:"sizeBytes,omitempty"`
+	// ImportPath is the directory on the backend adopted as the volume data
+	ImportPath string `json:"importPath,omitempty"`
+}
+
+type WarmupStatus struct {
+	State     string    `json:"state"`
+	Bytes     int64     `json:"bytes"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// CloneStatus of the copy of the data of another volume into a new volume
+type CloneStatus struct {
+	Source    string    `json:"source"`
+	State     string    `json:"state"`
+	Error     string    `json:"error,omitempty"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	// Node running the copy
+	Node string `json:"node,omitempty"`
+}
+
+// MountIDs holding a volume mounted, in mount order
+type MountIDs []string
+
+// UnmarshalJSON also accepts the single mount id string stored by earlier versions
+func (m *MountIDs) UnmarshalJSON(data []byte) error {
+	var id string
+	if json.Unmarshal(data, &id) == nil {
+		*m = nil
+		if id != "" {
+			*m = MountIDs{id}
+		}
+		return nil
+	}
+
+	var ids []string
+	err := json.Unmarshal(data, &ids)
+	if err != nil {
+		return err
+	}
+	*m = ids
+	return nil
+}
+
+type UsageStatus struct {
+	UsedBytes  uint64 `json:"usedBytes"`
+	TotalBytes uint64 `json:"totalBytes"`
+	FreeBytes  uint64 `json:"freeBytes"`
+	// LimitBytes is the size of the volume, 0 for no limit
+	LimitBytes uint64 `json:"limitBytes,omitempty"`
+	// OverQuota is set when the volume data uses more than its size
+	OverQuota bool      `json:"overQuota,omitempty"`
+	UpdatedAt time.Time `json:"updatedAt"`
 }
 
 type VolumeStatus struct {
-	MountBy string `json:"mountBy,omitempty"`
+	MountBy MountIDs      `json:"mountBy,omitempty"`
+	Warmup  *WarmupStatus `json:"warmup,omitempty"`
+	Clone   *CloneStatus  `json:"clone,omitempty"`
+	Usage   *UsageStatus  `json:"usage,omitempty"`
+	// MountNodes maps the mount ids in MountBy to the node holding the mount, ids mounted by earlier versions have none
+	MountNodes map[string]string `json:"mountNodes,omitempty"`
+	// MountClaimedAt maps the mount ids in MountNodes to the time their node recorded the mount
+	MountClaimedAt map[string]time.Time `json:"mountClaimedAt,omitempty"`
 }
 
 type VolumeMetadata struct {
@@ -38,4 +116,14 @@ type VolumeMetadata struct {
 	CreatedAt  time.Time     `json:"createAt"`
 	Spec       *VolumeSpec   `json:"spec"`
 	Status     *VolumeStatus `json:"status"`
+	// BackendData is populated and interpreted by the driver only, stores keep it opaque
+	BackendData json.RawMessage `json:"backendData,omitempty"`
+}
+
+type VolumeHistoryEntry struct {
+	Version   int       `json:"version"`
+	Timestamp time.Time `json:"timestamp"`
+	Operation string    `json:"operation"`
+	By        string    `json:"by,omitempty"`
+	Changes   []string  `json:"changes,omitempty"`
 }
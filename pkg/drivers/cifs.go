@@ -0,0 +1,430 @@
+package drivers
+
+import (
+	"context"
+	"docker-volume-plugin/pkg/drivers/apis"
+	"docker-volume-plugin/pkg/drivers/store"
+	"docker-volume-plugin/pkg/log"
+	"docker-volume-plugin/pkg/utils"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+func init() {
+	registerFactory("cifs", cifsFactory)
+}
+
+func cifsFactory(ctx context.Context, logger *log.Logger, propagatedMountpoint string, driverOptions string) (apis.Driver, error) {
+	opts := &cifsOptions{
+		PurgeAfterDelete:   false,
+		PurgeRetries:       3,
+		PurgeRetryInterval: "1s",
+		HistoryDepth:       20,
+		MetadataStore:      metadataStoreBadger,
+	}
+	err := json.Unmarshal([]byte(driverOptions), opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse driver options: %v", err)
+	}
+
+	if opts.Share != "" {
+		if opts.RemotePath != "" || strings.Contains(opts.Share, "/") {
+			return nil, fmt.Errorf("share must be a share name and can not be combined with remotePath")
+		}
+		opts.RemotePath = "/" + opts.Share
+	}
+
+	err = validateRemotePath(opts.RemotePath)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.CredentialsFile != "" && (opts.Username != "" || opts.Password != "") {
+		return nil, fmt.Errorf("credentialsFile can not be combined with username and password")
+	}
+
+	purgeRetryInterval, err := time.ParseDuration(opts.PurgeRetryInterval)
+	if err != nil {
+		return nil, fmt.Errorf("invalid purgeRetryInterval: %v", err)
+	}
+
+	err = validateMetadataStore(opts.MetadataStore)
+	if err != nil {
+		return nil, err
+	}
+
+	// Mount CIFS share to a local mount point only once every option is valid, so a mistake in them leaves nothing mounted
+	err = os.MkdirAll(propagatedMountpoint, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create CIFS mount point directory: %v", err)
+	}
+
+	mountOptions := []string{"vers=3.0", "rw"}
+	if opts.Domain != "" {
+		mountOptions = append(mountOptions, "domain="+opts.Domain)
+	}
+	if opts.CredentialsFile != "" {
+		mountOptions = append(mountOptions, "credentials="+opts.CredentialsFile)
+	}
+	mountOptions = utils.MergeMountOptions(mountOptions, opts.MountOptions)
+
+	if opts.Address != "cifs-server.mock" {
+		err = utils.MountCIFS(opts.Address, opts.RemotePath, propagatedMountpoint, mountOptions, opts.Username, opts.Password)
+		if err != nil {
+			return nil, fmt.Errorf("failed to mount CIFS share: %v", err)
+		}
+	}
+
+	db, err := newMetadataStore(logger, opts.MetadataStore, propagatedMountpoint, "", true)
+	if err != nil {
+		if opts.Address != "cifs-server.mock" {
+			umountErr := utils.Umount(propagatedMountpoint)
+			if umountErr != nil {
+				logger.Warningf("failed to unmount CIFS share on %s: %v", propagatedMountpoint, umountErr)
+			}
+		}
+		return nil, err
+	}
+
+	driver := &cifs{
+		logger:             logger,
+		opts:               opts,
+		purgeRetryInterval: purgeRetryInterval,
+		db:                 db,
+		rootPath:           propagatedMountpoint,
+		nameMax:            utils.NameMax(propagatedMountpoint),
+		driverLocks:        newDriverLocks(),
+		reservedPath:       metadataReservedPath(opts.MetadataStore),
+	}
+
+	return driver, nil
+}
+
+type cifsOptions struct {
+	// Address of CIFS server
+	Address string `json:"address"`
+	// RemotePath of the CIFS share, such as /share or /share/dir
+	RemotePath string `json:"remotePath"`
+	// Share is the name of the CIFS share, a shorthand for remotePath /<share>
+	Share string `json:"share,omitempty"`
+	// Username to authenticate with, only passed to the mount
+	Username string `json:"username,omitempty"`
+	// Password to authenticate with, only passed to the mount
+	Password string `json:"password,omitempty"`
+	// CredentialsFile is a mount.cifs credentials file, used instead of username and password
+	CredentialsFile string `json:"credentialsFile,omitempty"`
+	// Domain of the user
+	Domain string `json:"domain,omitempty"`
+	// MountOptions for CIFS, merged over vers=3.0, rw and the domain and credentials options
+	MountOptions []string `json:"mountOptions,omitempty"`
+	// PurgeAfterDelete indicates whether to purge the volume data after deletion
+	PurgeAfterDelete bool `json:"purgeAfterDelete,omitempty"`
+	// PurgeRetries is the number of attempts to purge the volume data on transient errors
+	PurgeRetries int `json:"purgeRetries,omitempty"`
+	// PurgeRetryInterval is the interval between attempts to purge the volume data
+	PurgeRetryInterval string `json:"purgeRetryInterval,omitempty"`
+	// HistoryDepth is the number of change history entries kept per volume, 0 disables the history
+	HistoryDepth int `json:"historyDepth"`
+	// MetadataStore selects the backend keeping the metadata, badger or jsonfile
+	MetadataStore string `json:"metadataStore,omitempty"`
+	// IgnoredVolumeOptions are volume option keys injected by the caller that are accepted and ignored
+	IgnoredVolumeOptions []string `json:"ignoredVolumeOptions,omitempty"`
+}
+
+type cifs struct {
+	logger       *log.Logger
+	opts         *cifsOptions
+	db           store.Store
+	rootPath     string
+	nameMax      int
+	reservedPath []string
+	// driverLocks serialize the operations on the driver and its volumes, see locks.go for the lock ordering
+	driverLocks
+	purgeRetryInterval time.Duration
+	// closed is set by Destroy, guarded by lock
+	closed bool
+	// destroyOnce runs the teardown once, later and concurrent Destroy calls return its destroyErr
+	destroyOnce sync.Once
+	destroyErr  error
+}
+
+func (c *cifs) validateVolumeName(name string) error {
+	switch {
+	case name == "" || name == "." || name == "..":
+		return fmt.Errorf("volume name %s is invalid: empty, . and .. are not allowed", name)
+	case strings.Contains(name, "/"):
+		return fmt.Errorf("volume name %s is invalid: / is not allowed", name)
+	case slices.Contains(c.reservedPath, name):
+		return fmt.Errorf("volume name %s is reserved, please choose a different name", name)
+	case len(name) > c.nameMax:
+		return fmt.Errorf("volume name %s is too long: %d bytes exceeds the %d bytes limit of the backend", name, len(name), c.nameMax)
+	}
+
+	return nil
+}
+
+func (c *cifs) Create(name string, options map[string]string) (err error) {
+	defer c.lockVolume(name, false)()
+
+	if c.closed {
+		return apis.ErrShuttingDown
+	}
+
+	err = c.validateVolumeName(name)
+	if err != nil {
+		return err
+	}
+
+	purgeAfterDelete := c.opts.PurgeAfterDelete
+	for key, value := range withoutIgnoredOptions(c.logger, name, options, c.opts.IgnoredVolumeOptions) {
+		switch key {
+		case "purgeAfterDelete":
+			purgeAfterDelete, err = strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("invalid value for purgeAfterDelete: %v", err)
+			}
+		default:
+			return fmt.Errorf("unknown option %s with value %s, ignoring", key, value)
+		}
+	}
+
+	c.logger.Infof("create volume %s", name)
+
+	err = checkDataPath(path.Join(c.rootPath, name, "_data"), true)
+	if err != nil {
+		return err
+	}
+
+	var created *apis.VolumeMetadata
+	err = backendError(runTwoPhase(createDirectoryChange(c.rootPath, name), func() error {
+		return c.db.CreateVolumeMetadata(name, func(volumeMetadata *apis.VolumeMetadata) error {
+			*volumeMetadata = apis.VolumeMetadata{
+				Mountpoint: path.Join(name, "_data"),
+				CreatedAt:  time.Now(),
+				Spec: &apis.VolumeSpec{
+					PurgeAfterDelete: purgeAfterDelete,
+				},
+				Status: &apis.VolumeStatus{},
+			}
+			created = cloneVolumeMetadata(volumeMetadata)
+			return nil
+		})
+	}))
+	if err != nil {
+		return err
+	}
+
+	appendHistory(c.logger, c.db, c.opts.HistoryDepth, name, historyCreate, "", nil, created)
+
+	return nil
+}
+
+func (c *cifs) List() (map[string]*apis.VolumeMetadata, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	c.logger.Info("list volumes")
+
+	volumeMetadataMap, err := c.db.GetVolumeMetadataMap()
+	if err != nil {
+		return volumeMetadataMap, err
+	}
+
+	for name := range volumeMetadataMap {
+		if err := c.validateVolumeName(name); err != nil {
+			c.logger.Warningf("skip invalid metadata entry %s: %v", name, err)
+			delete(volumeMetadataMap, name)
+		}
+	}
+
+	return volumeMetadataMap, nil
+}
+
+func (c *cifs) Get(name string) (*apis.VolumeMetadata, error) {
+	defer c.lockVolume(name, true)()
+
+	c.logger.Infof("get volume %s", name)
+
+	return c.db.GetVolumeMetadata(name)
+}
+
+func (c *cifs) Remove(name string) error {
+	defer c.lockVolume(name, false)()
+
+	c.logger.Infof("remove volume %s", name)
+
+	purge := false
+	var removed *apis.VolumeMetadata
+	purgeChange := fsChange{
+		Commit: func() error {
+			if !purge {
+				return nil
+			}
+
+			err := utils.RemoveAllWithRetry(path.Join(c.rootPath, name), c.opts.PurgeRetries, c.purgeRetryInterval)
+			if err != nil {
+				return fmt.Errorf("failed to remove volume data: %v", err)
+			}
+			return nil
+		},
+	}
+
+	err := runTwoPhase(purgeChange, func() error {
+		return c.db.DeleteVolumeMetadata(name, func(volumeMetadata *apis.VolumeMetadata) error {
+			if len(volumeMetadata.Status.MountBy) != 0 {
+				return fmt.Errorf("volume %s is mounted by %s, unmount it before removing", name, strings.Join(volumeMetadata.Status.MountBy, ", "))
+			}
+
+			purge = volumeMetadata.Spec.PurgeAfterDelete
+			removed = cloneVolumeMetadata(volumeMetadata)
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	appendHistory(c.logger, c.db, c.opts.HistoryDepth, name, historyRemove, "", removed, nil)
+
+	return nil
+}
+
+func (c *cifs) Path(name string) (string, error) {
+	defer c.lockVolume(name, true)()
+
+	c.logger.Infof("path volume %s", name)
+
+	err := c.validateVolumeName(name)
+	if err != nil {
+		return "", err
+	}
+
+	volumeMetadata, err := c.db.GetVolumeMetadata(name)
+	if err != nil {
+		return "", err
+	}
+
+	return path.Join(c.rootPath, volumeMetadata.Mountpoint), nil
+}
+
+func (c *cifs) Mount(name string, id string) (string, error) {
+	defer c.lockVolume(name, false)()
+
+	c.logger.Infof("mount volume %s for %s", name, id)
+
+	err := c.validateVolumeName(name)
+	if err != nil {
+		return "", err
+	}
+
+	var before, after *apis.VolumeMetadata
+	err = c.db.SetVolumeMetadata(name, func(volumeMetadata *apis.VolumeMetadata) error {
+		if slices.Contains(volumeMetadata.Status.MountBy, id) {
+			return fmt.Errorf("volume %s is already mounted by %s", name, id)
+		}
+
+		err := checkDataPath(path.Join(c.rootPath, volumeMetadata.Mountpoint), false)
+		if err != nil {
+			return err
+		}
+
+		before = cloneVolumeMetadata(volumeMetadata)
+		volumeMetadata.Status.MountBy = append(volumeMetadata.Status.MountBy, id)
+		after = cloneVolumeMetadata(volumeMetadata)
+		return nil
+	})
+	if err != nil {
+		return "", backendError(err)
+	}
+
+	appendHistory(c.logger, c.db, c.opts.HistoryDepth, name, historyMount, id, before, after)
+
+	return path.Join(c.rootPath, name, "_data"), nil
+}
+
+func (c *cifs) Unmount(name string, id string) error {
+	defer c.lockVolume(name, false)()
+
+	c.logger.Infof("unmount volume %s from %s", name, id)
+
+	var before, after *apis.VolumeMetadata
+	err := c.db.SetVolumeMetadata(name, func(volumeMetadata *apis.VolumeMetadata) error {
+		if len(volumeMetadata.Status.MountBy) == 0 {
+			return fmt.Errorf("volume %s is not mounted", name)
+		}
+
+		if !slices.Contains(volumeMetadata.Status.MountBy, id) {
+			return fmt.Errorf("volume %s is not mounted by %s, mounted by %s", name, id, strings.Join(volumeMetadata.Status.MountBy, ", "))
+		}
+
+		before = cloneVolumeMetadata(volumeMetadata)
+		volumeMetadata.Status.MountBy = slices.DeleteFunc(volumeMetadata.Status.MountBy, func(mountID string) bool { return mountID == id })
+		after = cloneVolumeMetadata(volumeMetadata)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	appendHistory(c.logger, c.db, c.opts.HistoryDepth, name, historyUnmount, id, before, after)
+
+	return nil
+}
+
+func (c *cifs) History(name string) ([]*apis.VolumeHistoryEntry, error) {
+	defer c.lockVolume(name, true)()
+
+	c.logger.Infof("history of volume %s", name)
+
+	return c.db.GetVolumeHistory(name)
+}
+
+func (c *cifs) Status() map[string]interface{} {
+	status := map[string]interface{}{
+		"address": c.opts.Address,
+	}
+
+	fsid, err := utils.FilesystemID(c.rootPath)
+	if err != nil {
+		c.logger.Warningf("failed to get filesystem id of %s: %v", c.rootPath, err)
+	} else {
+		status["fsid"] = fsid
+	}
+
+	return status
+}
+
+func (c *cifs) Destroy() error {
+	c.destroyOnce.Do(func() {
+		c.destroyErr = c.destroy()
+	})
+	return c.destroyErr
+}
+
+func (c *cifs) destroy() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.closed = true
+
+	err := c.db.Close()
+	if err != nil {
+		c.logger.Warningf("failed to close metadata store: %v", err)
+	}
+
+	if c.opts.Address != "cifs-server.mock" {
+		err = utils.Umount(c.rootPath)
+		if err != nil {
+			return fmt.Errorf("failed to unmount CIFS mount root path %s: %v", c.rootPath, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,203 @@
+package drivers
+
+import (
+	"context"
+	"docker-volume-plugin/pkg/log"
+	"encoding/json"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+var localCIFSServerDriverOptions string = `{
+	"address": "cifs-server.mock",
+	"share": "share",
+	"username": "user",
+	"password": "secret-password",
+	"domain": "EXAMPLE"
+}`
+
+func TestCIFSDriver(t *testing.T) {
+	propagatedMountpoint := t.TempDir()
+	driver, err := New(context.Background(), log.New("test-cifs").WithLogLevel(log.WarnLevel), "cifs", propagatedMountpoint, localCIFSServerDriverOptions)
+	if err != nil {
+		t.Fatalf("got error when new cifs driver: %v", err)
+	}
+	defer func() {
+		if err := driver.Destroy(); err != nil {
+			t.Errorf("got error when destroy cifs driver: %v", err)
+		}
+	}()
+
+	err = driver.Create("test", map[string]string{"purgeAfterDelete": "true"})
+	if err != nil {
+		t.Fatalf("got error when create volume test: %v", err)
+	}
+	err = driver.Create("metadata.db", nil)
+	if err == nil {
+		t.Fatalf("expect got error when create volume with reserved name")
+	}
+
+	// Credentials are only passed to the mount and never stored with the volume
+	volumeMetadata, err := driver.Get("test")
+	if err != nil {
+		t.Fatalf("got error when get volume test: %v", err)
+	}
+	value, err := json.Marshal(volumeMetadata)
+	if err != nil {
+		t.Fatalf("got error when marshal volume metadata: %v", err)
+	}
+	if strings.Contains(string(value), "secret-password") {
+		t.Errorf("expected no password in volume metadata, got %s", string(value))
+	}
+
+	mountpoint, err := driver.Mount("test", "1")
+	if err != nil {
+		t.Fatalf("got error when mount volume test: %v", err)
+	}
+	if mountpoint != path.Join(propagatedMountpoint, "test", "_data") {
+		t.Errorf("expected mountpoint %s, got %s", path.Join(propagatedMountpoint, "test", "_data"), mountpoint)
+	}
+	_, err = os.Stat(mountpoint)
+	if err != nil {
+		t.Errorf("expected volume data directory, got %v", err)
+	}
+	_, err = driver.Mount("test", "2")
+	if err != nil {
+		t.Fatalf("got error when mount volume test for another container: %v", err)
+	}
+
+	err = driver.Remove("test")
+	if err == nil {
+		t.Fatalf("expect got error when remove mounted volume test")
+	}
+	for _, id := range []string{"1", "2"} {
+		err = driver.Unmount("test", id)
+		if err != nil {
+			t.Fatalf("got error when unmount volume test from %s: %v", id, err)
+		}
+	}
+
+	err = driver.Remove("test")
+	if err != nil {
+		t.Fatalf("got error when remove volume test: %v", err)
+	}
+	_, err = os.Stat(path.Join(propagatedMountpoint, "test"))
+	if !os.IsNotExist(err) {
+		t.Errorf("expected volume data purged, got %v", err)
+	}
+
+	volumeMetadataMap, err := driver.List()
+	if err != nil {
+		t.Fatalf("got error when list volumes: %v", err)
+	}
+	if len(volumeMetadataMap) != 0 {
+		t.Errorf("expected 0 volumes, got %d", len(volumeMetadataMap))
+	}
+}
+
+func TestCIFSDriverInvalidOptions(t *testing.T) {
+	for _, driverOptions := range []string{
+		`{"address": "cifs-server.mock"}`,
+		`{"address": "cifs-server.mock", "remotePath": "/share", "username": "user", "credentialsFile": "/etc/cifs-credentials"}`,
+		`{"address": "cifs-server.mock", "share": "share", "remotePath": "/share"}`,
+		`{"address": "cifs-server.mock", "share": "share/dir"}`,
+	} {
+		_, err := New(context.Background(), log.New("test-cifs").WithLogLevel(log.WarnLevel), "cifs", t.TempDir(), driverOptions)
+		if err == nil {
+			t.Errorf("expected error for driver options %s", driverOptions)
+		}
+	}
+}
+
+func TestCIFSDriverInvalidOptionsBeforeMount(t *testing.T) {
+	// The address is never reachable, so any of these options mounting the share first fails with a mount error
+	for option, driverOptions := range map[string]string{
+		"purgeRetryInterval": `{"address": "192.0.2.1", "share": "share", "purgeRetryInterval": "1"}`,
+		"metadataStore":      `{"address": "192.0.2.1", "share": "share", "metadataStore": "sqlite"}`,
+	} {
+		propagatedMountpoint := path.Join(t.TempDir(), "mnt")
+		_, err := New(context.Background(), log.New("test-cifs").WithLogLevel(log.WarnLevel), "cifs", propagatedMountpoint, driverOptions)
+		if err == nil || !strings.Contains(err.Error(), option) {
+			t.Errorf("expected %s error for driver options %s, got %v", option, driverOptions, err)
+		}
+		_, err = os.Stat(propagatedMountpoint)
+		if !os.IsNotExist(err) {
+			t.Errorf("expected no mount point created for driver options %s, got %v", driverOptions, err)
+		}
+	}
+}
+
+func TestCIFSDriverIgnoredOptions(t *testing.T) {
+	driver, err := New(context.Background(), log.New("test-cifs").WithLogLevel(log.WarnLevel), "cifs", t.TempDir(), `{
+		"address": "cifs-server.mock",
+		"share": "share",
+		"ignoredVolumeOptions": ["com.example.injected"]
+	}`)
+	if err != nil {
+		t.Fatalf("got error when new cifs driver: %v", err)
+	}
+	defer func() {
+		if err := driver.Destroy(); err != nil {
+			t.Errorf("got error when destroy cifs driver: %v", err)
+		}
+	}()
+
+	err = driver.Create("ignored-options", map[string]string{"com.example.injected": "value"})
+	if err != nil {
+		t.Errorf("got error when create volume with ignored option: %v", err)
+	}
+	err = driver.Create("unknown-options", map[string]string{"unknown": "value"})
+	if err == nil {
+		t.Errorf("expect got error when create volume with unknown option")
+	}
+}
+
+func TestCIFSDriverVolumeLocking(t *testing.T) {
+	driver, err := New(context.Background(), log.New("test-cifs").WithLogLevel(log.WarnLevel), "cifs", t.TempDir(), localCIFSServerDriverOptions)
+	if err != nil {
+		t.Fatalf("got error when new cifs driver: %v", err)
+	}
+	defer func() {
+		if err := driver.Destroy(); err != nil {
+			t.Errorf("got error when destroy cifs driver: %v", err)
+		}
+	}()
+
+	for _, name := range []string{"busy", "idle"} {
+		err = driver.Create(name, nil)
+		if err != nil {
+			t.Fatalf("got error when create volume %s: %v", name, err)
+		}
+	}
+
+	// An operation holding volume busy only blocks the operations on busy
+	release := driver.(*cifs).lockVolume("busy", false)
+	done := make(chan error, 1)
+	go func() {
+		_, err := driver.List()
+		if err == nil {
+			_, err = driver.Get("idle")
+		}
+		if err == nil {
+			_, err = driver.Mount("idle", "1")
+		}
+		done <- err
+	}()
+	select {
+	case err = <-done:
+		if err != nil {
+			t.Errorf("got error when use volume idle: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("expected operations on volume idle not blocked by volume busy")
+	}
+	release()
+
+	_, err = driver.Get("busy")
+	if err != nil {
+		t.Errorf("got error when get volume busy: %v", err)
+	}
+}
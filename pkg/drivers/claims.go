@@ -0,0 +1,226 @@
+package drivers
+
+import (
+	"context"
+	"docker-volume-plugin/pkg/drivers/apis"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"time"
+)
+
+var _ apis.ForceRemover = &nfs{}
+
+// nodeLeasesPath is the directory on the share holding a lease file per node, refreshed by its heartbeat
+const nodeLeasesPath = "metadata.nodes"
+
+// validateNodeID rejects node ids that can not name a lease file
+func validateNodeID(nodeID string) error {
+	if nodeID == "" || nodeID == "." || nodeID == ".." || strings.Contains(nodeID, "/") {
+		return fmt.Errorf("invalid nodeID %s: empty, ., .. and / are not allowed", nodeID)
+	}
+	return nil
+}
+
+// leasePath of node
+func (n *nfs) leasePath(node string) string {
+	return path.Join(n.metadataPath, nodeLeasesPath, node)
+}
+
+// renewLease records the current time in the lease of this node. The time is read back from the file content rather
+// than its modification time, as opening the file revalidates the NFS attribute cache while a stat may not.
+func (n *nfs) renewLease() error {
+	err := os.MkdirAll(path.Dir(n.leasePath(n.nodeID)), 0755)
+	if err != nil {
+		return fmt.Errorf("failed to create node lease directory: %v", err)
+	}
+
+	err = os.WriteFile(n.leasePath(n.nodeID), []byte(time.Now().UTC().Format(time.RFC3339Nano)), 0644)
+	if err != nil {
+		return fmt.Errorf("failed to renew lease of node %s: %v", n.nodeID, err)
+	}
+	return nil
+}
+
+// heartbeat renews the lease of this node every quarter of claimTTL until ctx is done
+func (n *nfs) heartbeat(ctx context.Context) {
+	defer close(n.heartbeatDone)
+
+	ticker := time.NewTicker(n.claimTTL / 4)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if n.checkBackend() != nil {
+			continue
+		}
+		err := n.renewLease()
+		if err != nil {
+			n.logger.Warningf("%v", err)
+		}
+	}
+}
+
+// claimStale reports whether mount id of volumeMetadata may be taken over, as the lease of its node was not renewed
+// within claimTTL. A node without a lease may still be starting and a mount of an earlier version has no node, so
+// these are only stale once claimTTL passed since they were claimed. Mounts of this node and of nodes with an
+// unreadable lease are never stale.
+func (n *nfs) claimStale(volumeMetadata *apis.VolumeMetadata, id string) bool {
+	node := volumeMetadata.Status.MountNodes[id]
+	if node == n.nodeID || n.claimTTL <= 0 {
+		return false
+	}
+	if node == "" {
+		return n.claimExpired(volumeMetadata, id)
+	}
+
+	content, err := os.ReadFile(n.leasePath(node))
+	if os.IsNotExist(err) {
+		return n.claimExpired(volumeMetadata, id)
+	}
+	if err != nil {
+		n.logger.Warningf("failed to read lease of node %s, keep its mounts: %v", node, err)
+		return false
+	}
+
+	renewedAt, err := time.Parse(time.RFC3339Nano, strings.TrimSpace(string(content)))
+	if err != nil {
+		n.logger.Warningf("failed to parse lease of node %s, keep its mounts: %v", node, err)
+		return false
+	}
+	return time.Since(renewedAt) > n.claimTTL
+}
+
+// claimExpired reports whether claimTTL passed since mount id of volumeMetadata was claimed
+func (n *nfs) claimExpired(volumeMetadata *apis.VolumeMetadata, id string) bool {
+	claimedAt, ok := volumeMetadata.Status.MountClaimedAt[id]
+	return ok && time.Since(claimedAt) > n.claimTTL
+}
+
+// legacyClaims returns the mounts of volumeMetadata recorded by earlier versions, which have neither a node nor a
+// claim time
+func legacyClaims(volumeMetadata *apis.VolumeMetadata) []string {
+	ids := []string{}
+	for _, id := range volumeMetadata.Status.MountBy {
+		_, claimed := volumeMetadata.Status.MountClaimedAt[id]
+		if volumeMetadata.Status.MountNodes[id] == "" && !claimed {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// stampLegacyClaims records the current time as the claim time of the mounts of earlier versions, whose node is
+// unknown, so that they go stale once claimTTL passed instead of keeping their volumes forever. It must be called
+// before the driver serves any request.
+func (n *nfs) stampLegacyClaims() {
+	volumeMetadataMap, err := n.db.GetVolumeMetadataMap()
+	if err != nil {
+		n.logger.Warningf("failed to list volumes to check mounts of earlier versions: %v", err)
+		return
+	}
+
+	for name, volumeMetadata := range volumeMetadataMap {
+		if len(legacyClaims(volumeMetadata)) == 0 {
+			continue
+		}
+
+		err := n.db.SetVolumeMetadata(name, func(volumeMetadata *apis.VolumeMetadata) error {
+			ids := legacyClaims(volumeMetadata)
+			if len(ids) != 0 && volumeMetadata.Status.MountClaimedAt == nil {
+				volumeMetadata.Status.MountClaimedAt = map[string]time.Time{}
+			}
+			for _, id := range ids {
+				volumeMetadata.Status.MountClaimedAt[id] = time.Now().UTC()
+			}
+			return nil
+		})
+		if err != nil {
+			n.logger.Warningf("failed to record claim time of mounts of volume %s from earlier versions: %v", name, err)
+			continue
+		}
+		n.logger.Infof("mounts %s of volume %s from earlier versions without a node go stale in %s", strings.Join(legacyClaims(volumeMetadata), ", "), name, n.claimTTL)
+	}
+}
+
+// describeMount names mount id of volumeMetadata with the node holding it
+func describeMount(volumeMetadata *apis.VolumeMetadata, id string) string {
+	if node := volumeMetadata.Status.MountNodes[id]; node != "" {
+		return fmt.Sprintf("%s on node %s", id, node)
+	}
+	return id
+}
+
+// describeMounts names all mounts of volumeMetadata, grouping the ids held by the same node
+func describeMounts(volumeMetadata *apis.VolumeMetadata) string {
+	groups := []string{}
+	ids := []string{}
+	for i, id := range volumeMetadata.Status.MountBy {
+		ids = append(ids, id)
+
+		node := volumeMetadata.Status.MountNodes[id]
+		last := i == len(volumeMetadata.Status.MountBy)-1
+		if !last && volumeMetadata.Status.MountNodes[volumeMetadata.Status.MountBy[i+1]] == node {
+			continue
+		}
+
+		group := strings.Join(ids, ", ")
+		if node != "" {
+			group += " on node " + node
+		}
+		groups = append(groups, group)
+		ids = nil
+	}
+	return strings.Join(groups, ", ")
+}
+
+// claimMount records mount id of volumeMetadata as held by this node
+func (n *nfs) claimMount(volumeMetadata *apis.VolumeMetadata, id string) {
+	if !slices.Contains(volumeMetadata.Status.MountBy, id) {
+		volumeMetadata.Status.MountBy = append(volumeMetadata.Status.MountBy, id)
+	}
+	if volumeMetadata.Status.MountNodes == nil {
+		volumeMetadata.Status.MountNodes = map[string]string{}
+	}
+	volumeMetadata.Status.MountNodes[id] = n.nodeID
+	if volumeMetadata.Status.MountClaimedAt == nil {
+		volumeMetadata.Status.MountClaimedAt = map[string]time.Time{}
+	}
+	volumeMetadata.Status.MountClaimedAt[id] = time.Now().UTC()
+}
+
+// releaseClaim drops mount id from volumeMetadata
+func releaseClaim(volumeMetadata *apis.VolumeMetadata, id string) {
+	volumeMetadata.Status.MountBy = slices.DeleteFunc(volumeMetadata.Status.MountBy, func(mountID string) bool { return mountID == id })
+	delete(volumeMetadata.Status.MountNodes, id)
+	if len(volumeMetadata.Status.MountNodes) == 0 {
+		volumeMetadata.Status.MountNodes = nil
+	}
+	delete(volumeMetadata.Status.MountClaimedAt, id)
+	if len(volumeMetadata.Status.MountClaimedAt) == 0 {
+		volumeMetadata.Status.MountClaimedAt = nil
+	}
+}
+
+// dropStaleClaims drops the mounts of volume name held by nodes whose lease expired, or all of them with force
+func (n *nfs) dropStaleClaims(name string, volumeMetadata *apis.VolumeMetadata, force bool) {
+	for _, id := range slices.Clone(volumeMetadata.Status.MountBy) {
+		if !force && !n.claimStale(volumeMetadata, id) {
+			continue
+		}
+
+		if force {
+			n.logger.Warningf("forced removal drops mount %s of volume %s", describeMount(volumeMetadata, id), name)
+		} else {
+			n.logger.Warningf("drop mount %s of volume %s, the lease of its node expired", describeMount(volumeMetadata, id), name)
+		}
+		releaseClaim(volumeMetadata, id)
+	}
+}
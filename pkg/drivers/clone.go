@@ -0,0 +1,126 @@
+package drivers
+
+import (
+	"context"
+	"docker-volume-plugin/pkg/drivers/apis"
+	"docker-volume-plugin/pkg/utils"
+	"path"
+	"time"
+)
+
+const (
+	cloneRunning   = "running"
+	cloneCompleted = "completed"
+	cloneFailed    = "failed"
+)
+
+// Errors recorded for clones that stopped with the plugin
+const (
+	cloneShutdownError = "interrupted by plugin shutdown"
+	cloneRestartError  = "interrupted by plugin restart"
+)
+
+// volumeClone is a background copy of the data of another volume into a new volume
+type volumeClone struct {
+	source string
+	cancel context.CancelFunc
+	// done is closed once the copy stopped writing with err, before the result is recorded under the volume lock
+	done chan struct{}
+	err  error
+}
+
+// startClone copies the data of volume source into volume name in background and records the result in its status
+func (n *nfs) startClone(name string, source string) {
+	n.clonesLock.Lock()
+	defer n.clonesLock.Unlock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	current := &volumeClone{source: source, cancel: cancel, done: make(chan struct{})}
+	n.clones[name] = current
+
+	go func() {
+		defer cancel()
+
+		err := utils.CopyTree(ctx, path.Join(n.rootPath, source, "_data"), path.Join(n.rootPath, name, "_data"))
+		current.err = err
+		close(current.done)
+
+		defer n.lockVolume(name, false)()
+
+		n.clonesLock.Lock()
+		latest := n.clones[name] == current
+		if latest {
+			delete(n.clones, name)
+		}
+		n.clonesLock.Unlock()
+
+		// A stopped clone is recorded by whoever stopped it
+		if !latest || n.closed {
+			return
+		}
+
+		status := &apis.CloneStatus{Source: source, State: cloneCompleted, UpdatedAt: time.Now(), Node: n.nodeID}
+		if err != nil {
+			n.logger.Warningf("failed to clone volume %s from %s: %v", name, source, err)
+			status.State = cloneFailed
+			status.Error = err.Error()
+		} else {
+			n.logger.Infof("clone of volume %s from %s completed", name, source)
+		}
+
+		n.setCloneStatus(name, status)
+	}()
+}
+
+// setCloneStatus records status as the clone status of volume name, must be called with the volume locked
+func (n *nfs) setCloneStatus(name string, status *apis.CloneStatus) {
+	var before, after *apis.VolumeMetadata
+	err := n.db.SetVolumeMetadata(name, func(volumeMetadata *apis.VolumeMetadata) error {
+		before = cloneVolumeMetadata(volumeMetadata)
+		volumeMetadata.Status.Clone = status
+		after = cloneVolumeMetadata(volumeMetadata)
+		return nil
+	})
+	if err != nil {
+		n.logger.Warningf("failed to record clone status of volume %s: %v", name, err)
+		return
+	}
+
+	n.recordHistory(name, historyClone, "", before, after)
+}
+
+// failInterruptedClones marks the clones left running by this node as failed, as the plugin stopped without
+// recording their result. It must be called before the driver serves any request.
+func (n *nfs) failInterruptedClones() {
+	volumeMetadataMap, err := n.db.GetVolumeMetadataMap()
+	if err != nil {
+		n.logger.Warningf("failed to list volumes to check interrupted clones: %v", err)
+		return
+	}
+
+	for name, volumeMetadata := range volumeMetadataMap {
+		clone := volumeMetadata.Status.Clone
+		if clone == nil || clone.State != cloneRunning || clone.Node != n.nodeID {
+			continue
+		}
+
+		n.logger.Warningf("clone of volume %s from %s was interrupted by a restart, remove the volume and clone it again", name, clone.Source)
+		n.setCloneStatus(name, &apis.CloneStatus{Source: clone.Source, State: cloneFailed, Error: cloneRestartError, UpdatedAt: time.Now(), Node: n.nodeID})
+	}
+}
+
+// stopClone cancels the clone into volume name, if any, and waits until it stopped writing.
+// It must be called with the volume locked, which keeps the clone from recording its result.
+func (n *nfs) stopClone(name string) {
+	n.clonesLock.Lock()
+	current := n.clones[name]
+	delete(n.clones, name)
+	n.clonesLock.Unlock()
+
+	if current == nil {
+		return
+	}
+
+	current.cancel()
+	<-current.done
+}
@@ -5,6 +5,7 @@ import (
 	"docker-volume-plugin/pkg/drivers/apis"
 	"docker-volume-plugin/pkg/log"
 	"fmt"
+	"slices"
 )
 
 type driverFactory func(ctx context.Context, logger *log.Logger, propagatedMountpoint string, driverOptions string) (apis.Driver, error)
@@ -23,5 +24,32 @@ func New(ctx context.Context, logger *log.Logger, name string, propagatedMountpo
 		return nil, fmt.Errorf("driver %s is invalid", name)
 	}
 
-	return factory(ctx, logger.WithService(name), propagatedMountpoint, driverOptions)
+	driverLogger := logger.WithService(name)
+	driver, err := factory(ctx, driverLogger, propagatedMountpoint, driverOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	instrumented, err := withMetrics(ctx, driverLogger, name, driver, driverOptions)
+	if err != nil {
+		if destroyErr := driver.Destroy(); destroyErr != nil {
+			driverLogger.Warningf("failed to destroy driver: %v", destroyErr)
+		}
+		return nil, err
+	}
+	return instrumented, nil
+}
+
+// withoutIgnoredOptions returns the volume options of volume name without the keys in ignored, which callers such as
+// orchestrators inject for themselves
+func withoutIgnoredOptions(logger *log.Logger, name string, options map[string]string, ignored []string) map[string]string {
+	filtered := make(map[string]string, len(options))
+	for key, value := range options {
+		if slices.Contains(ignored, key) {
+			logger.Debugf("ignore option %s with value %s for volume %s", key, value, name)
+			continue
+		}
+		filtered[key] = value
+	}
+	return filtered
 }
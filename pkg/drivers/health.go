@@ -0,0 +1,232 @@
+package drivers
+
+import (
+	"context"
+	"docker-volume-plugin/pkg/utils"
+	"errors"
+	"fmt"
+	"maps"
+	"math/rand/v2"
+	"os"
+	"path"
+	"slices"
+	"syscall"
+	"time"
+)
+
+var (
+	// errNotMounted is returned by probeMount when the share is no longer mounted
+	errNotMounted = errors.New("is not mounted")
+	// errUnresponsive is returned by probeMount when the probe does not answer within the timeout
+	errUnresponsive = errors.New("does not respond")
+)
+
+// probeMount returns an error if mountPath is not a mount point or probePath under it does not answer a stat within
+// timeout, as happens when the NFS server went away and the kernel mount is gone, stale or hung
+func probeMount(mountPath string, probePath string, timeout time.Duration) error {
+	mounted, err := utils.IsMounted(mountPath)
+	if err != nil {
+		return fmt.Errorf("failed to check mount %s: %w", mountPath, err)
+	}
+	if !mounted {
+		return fmt.Errorf("%s %w", mountPath, errNotMounted)
+	}
+
+	// A stat on a hard mount of an unreachable server blocks until the server is back, the goroutine ends with it
+	result := make(chan error, 1)
+	go func() {
+		_, err := os.Stat(probePath)
+		result <- err
+	}()
+
+	select {
+	case err := <-result:
+		if err != nil {
+			return fmt.Errorf("failed to stat %s: %w", probePath, err)
+		}
+		return nil
+	case <-time.After(timeout):
+		return fmt.Errorf("%s %w after %s", probePath, errUnresponsive, timeout)
+	}
+}
+
+// needsRemount reports whether err of probeMount means the mount is gone, stale or hung,
+// rather than a problem with the probe file itself
+func needsRemount(err error) bool {
+	return errors.Is(err, errNotMounted) || errors.Is(err, errUnresponsive) ||
+		errors.Is(err, syscall.ESTALE) || errors.Is(err, syscall.EIO)
+}
+
+// nextBackoff doubles backoff up to maxBackoff
+func nextBackoff(backoff time.Duration, maxBackoff time.Duration) time.Duration {
+	backoff *= 2
+	if backoff > maxBackoff {
+		return maxBackoff
+	}
+	return backoff
+}
+
+// withJitter adds up to a fifth of delay to it, so that nodes losing the same server do not remount in lockstep
+func withJitter(delay time.Duration) time.Duration {
+	if delay < 5 {
+		return delay
+	}
+	return delay + rand.N(delay/5)
+}
+
+// healthCheck probes the NFS share every healthCheckInterval until ctx is done.
+// While the share is unavailable, volume operations fail fast and it is remounted with exponential backoff.
+func (n *nfs) healthCheck(ctx context.Context) {
+	defer close(n.healthCheckDone)
+
+	// A background mount is left to mount.nfs until it first succeeded
+	mounted := !n.opts.MountBackground
+	delay := n.healthCheckInterval
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		err := n.probeShare()
+		if err == nil || !mounted || !needsRemount(err) {
+			if err != nil && mounted {
+				n.logger.Warningf("failed to probe NFS share: %v", err)
+			}
+			mounted = mounted || err == nil
+			delay = n.healthCheckInterval
+			timer.Reset(delay)
+			continue
+		}
+
+		// Operations fail fast from now on instead of hanging on the dead mount
+		n.unhealthy.Store(true)
+		n.logger.Warningf("NFS share is unavailable, remount it: %v", err)
+
+		err = n.remount(ctx)
+		if err != nil {
+			delay = nextBackoff(delay, n.healthCheckMaxBackoff)
+			n.logger.Errorf("failed to remount NFS share, retry in %s: %v", delay, err)
+			timer.Reset(withJitter(delay))
+			continue
+		}
+
+		n.unhealthy.Store(false)
+		n.logger.Infof("NFS share is remounted on %s", n.rootPath)
+		// Operations hanging on the dead mount may hold volume locks, which the health check does not wait for
+		go n.releaseAllPendingUnmounts()
+		delay = n.healthCheckInterval
+		timer.Reset(delay)
+	}
+}
+
+// probeShare stats the metadata through the mounts backing the driver, a lookup under the mount root
+// reaches the server where a stat of the root itself may be answered from the attribute cache
+func (n *nfs) probeShare() error {
+	err := probeMount(n.rootPath, path.Join(n.rootPath, n.metadataFile), n.healthCheckTimeout)
+	if err == nil && n.metadataPath != n.rootPath {
+		err = probeMount(n.metadataPath, path.Join(n.metadataPath, n.metadataFile), n.healthCheckTimeout)
+	}
+	return err
+}
+
+// detachMount lazily unmounts the share on mountpoint if it is mounted, which never waits on a dead server
+func detachMount(mountpoint string) error {
+	mounted, err := utils.IsMounted(mountpoint)
+	if err != nil || !mounted {
+		return err
+	}
+	return utils.UmountLazy(mountpoint)
+}
+
+// remount detaches the mounts backing the driver and mounts them again with the options they were mounted with.
+// Operations still hanging on the dead mount may hold the driver lock, so it runs with unhealthy set instead.
+func (n *nfs) remount(ctx context.Context) error {
+	if ctx.Err() != nil {
+		return nil
+	}
+
+	type mount struct {
+		path         string
+		mountOptions []string
+	}
+	mounts := []mount{{path: n.rootPath, mountOptions: n.opts.MountOptions}}
+	if n.metadataPath != n.rootPath {
+		mounts = append(mounts, mount{path: n.metadataPath, mountOptions: coherentMountOptions(n.opts.MountOptions)})
+	}
+
+	for _, mount := range mounts {
+		err := detachMount(mount.path)
+		if err != nil {
+			n.logger.Warningf("failed to detach NFS share on %s before remount: %v", mount.path, err)
+		}
+
+		err = utils.MountNFS(ctx, n.opts.Address, n.remotePath, mount.path, mount.mountOptions)
+		if err != nil {
+			return fmt.Errorf("failed to mount NFS share on %s: %w", mount.path, err)
+		}
+	}
+
+	// The store keeps the lock file on the share open, its handle went stale with the old mount. Operations hanging
+	// on the old mount may still hold it, which the reopen does not wait for.
+	n.db.Reopen()
+	n.refreshServerIdentity()
+
+	return nil
+}
+
+// unmountUnavailable releases the bind mount of volume name for id while the share is unavailable, so that the
+// container can stop. The metadata can not be written until the share is back, the claim is only released then.
+func (n *nfs) unmountUnavailable(name string, id string, backendErr error) error {
+	err := detachMount(path.Join(n.rootPath, mountPath(name, id)))
+	if err != nil {
+		return fmt.Errorf("%v, and failed to detach mount of volume %s for %s: %v", backendErr, name, id, err)
+	}
+
+	n.pendingUnmountsLock.Lock()
+	defer n.pendingUnmountsLock.Unlock()
+
+	if !slices.Contains(n.pendingUnmounts[name], id) {
+		n.pendingUnmounts[name] = append(n.pendingUnmounts[name], id)
+	}
+	n.logger.Warningf("unmount volume %s from %s, its claim is released once the share is back: %v", name, id, backendErr)
+	return nil
+}
+
+// releasePendingUnmounts releases the claims of the mounts of volume name unmounted while the share was unavailable,
+// holding the lock of volume name with the share available
+func (n *nfs) releasePendingUnmounts(name string) {
+	n.pendingUnmountsLock.Lock()
+	ids := n.pendingUnmounts[name]
+	delete(n.pendingUnmounts, name)
+	n.pendingUnmountsLock.Unlock()
+
+	for _, id := range ids {
+		err := n.releaseMount(name, id)
+		if err != nil {
+			n.logger.Warningf("failed to release claim of volume %s for %s unmounted while the share was unavailable: %v", name, id, err)
+		}
+	}
+}
+
+// releaseAllPendingUnmounts releases the claims of all mounts unmounted while the share was unavailable
+func (n *nfs) releaseAllPendingUnmounts() {
+	n.pendingUnmountsLock.Lock()
+	names := slices.Collect(maps.Keys(n.pendingUnmounts))
+	n.pendingUnmountsLock.Unlock()
+
+	for _, name := range names {
+		func() {
+			defer n.lockVolume(name, false)()
+
+			if n.closed || n.checkBackend() != nil {
+				return
+			}
+			n.releasePendingUnmounts(name)
+		}()
+	}
+}
@@ -0,0 +1,108 @@
+package drivers
+
+import (
+	"docker-volume-plugin/pkg/drivers/apis"
+	"docker-volume-plugin/pkg/drivers/store"
+	"docker-volume-plugin/pkg/log"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"time"
+)
+
+const (
+	historyCreate  = "create"
+	historyRemove  = "remove"
+	historyMount   = "mount"
+	historyUnmount = "unmount"
+	historyWarmup  = "warmup"
+	historyClone   = "clone"
+	historyAdopt   = "adopt"
+)
+
+// cloneVolumeMetadata returns a deep copy of volumeMetadata to compare it before and after a change
+func cloneVolumeMetadata(volumeMetadata *apis.VolumeMetadata) *apis.VolumeMetadata {
+	value, err := json.Marshal(volumeMetadata)
+	if err != nil {
+		return nil
+	}
+
+	clone := &apis.VolumeMetadata{}
+	if json.Unmarshal(value, clone) != nil {
+		return nil
+	}
+	return clone
+}
+
+// diffVolumeMetadata describes the spec and status fields changed from before to after, a nil side is treated as empty
+func diffVolumeMetadata(before *apis.VolumeMetadata, after *apis.VolumeMetadata) []string {
+	fields := func(volumeMetadata *apis.VolumeMetadata) map[string]string {
+		fields := map[string]string{}
+		if volumeMetadata == nil {
+			return fields
+		}
+
+		for section, value := range map[string]interface{}{"spec": volumeMetadata.Spec, "status": volumeMetadata.Status} {
+			raw, err := json.Marshal(value)
+			if err != nil {
+				continue
+			}
+			sectionFields := map[string]json.RawMessage{}
+			if json.Unmarshal(raw, &sectionFields) != nil {
+				continue
+			}
+			for key, value := range sectionFields {
+				fields[section+"."+key] = string(value)
+			}
+		}
+		return fields
+	}
+
+	beforeFields := fields(before)
+	afterFields := fields(after)
+
+	changes := []string{}
+	for key, value := range afterFields {
+		if beforeFields[key] != value {
+			changes = append(changes, fmt.Sprintf("%s: %s -> %s", key, orNone(beforeFields[key]), value))
+		}
+	}
+	for key, value := range beforeFields {
+		if _, ok := afterFields[key]; !ok {
+			changes = append(changes, fmt.Sprintf("%s: %s -> none", key, value))
+		}
+	}
+	slices.Sort(changes)
+
+	return changes
+}
+
+func orNone(value string) string {
+	if value == "" {
+		return "none"
+	}
+	return value
+}
+
+// recordHistory appends a change of volume name to its history, must be called with the volume locked.
+// History is best effort, a failure to record it does not fail the operation.
+func (n *nfs) recordHistory(name string, operation string, by string, before *apis.VolumeMetadata, after *apis.VolumeMetadata) {
+	appendHistory(n.logger, n.db, n.opts.HistoryDepth, name, operation, by, before, after)
+}
+
+// appendHistory records a change of volume name in db keeping depth entries, 0 disables the history
+func appendHistory(logger *log.Logger, db store.Store, depth int, name string, operation string, by string, before *apis.VolumeMetadata, after *apis.VolumeMetadata) {
+	if depth <= 0 {
+		return
+	}
+
+	err := db.AppendVolumeHistory(name, &apis.VolumeHistoryEntry{
+		Timestamp: time.Now(),
+		Operation: operation,
+		By:        by,
+		Changes:   diffVolumeMetadata(before, after),
+	}, depth)
+	if err != nil {
+		logger.Warningf("failed to record %s history of volume %s: %v", operation, name, err)
+	}
+}
@@ -0,0 +1,99 @@
+package drivers
+
+import "sync"
+
+// Lock ordering of the nfs and cifs drivers:
+//
+//  1. lock, the driver lock. Operations on a single volume and List hold it shared, so a slow operation only blocks
+//     the operations on its own volume. Destroy and the create and remove of nested nfs volumes hold it exclusively,
+//     which excludes every other operation and gives them a consistent view of all volumes. The remount of the nfs
+//     health check does not take it, operations hanging on the dead mount could hold it, and keeps operations off the
+//     share with unhealthy instead.
+//  2. A volume lock from volumeLocks, only taken while holding lock shared. Read operations hold it shared.
+//     No operation holds the locks of two volumes, List takes the lock of an orphan volume directory one at a time.
+//  3. warmupsLock, clonesLock and pendingUnmountsLock, only held around accesses to the warmups, clones and pending
+//     unmounts maps, which do not take any other lock.
+//
+// The metadata store serializes its own accesses, so a single metadata update is atomic under any of these locks.
+// Disk usage walks run without any of these locks, only storing their result takes the volume lock.
+
+// volumeLocks hands out a lock per volume name, dropping it once no operation holds or waits for it
+type volumeLocks struct {
+	lock  sync.Mutex
+	locks map[string]*volumeLock
+}
+
+type volumeLock struct {
+	sync.RWMutex
+	refs int
+}
+
+func newVolumeLocks() *volumeLocks {
+	return &volumeLocks{locks: map[string]*volumeLock{}}
+}
+
+// acquire takes the lock of volume name, shared when read is set, and returns the function releasing it
+func (v *volumeLocks) acquire(name string, read bool) func() {
+	v.lock.Lock()
+	lock := v.locks[name]
+	if lock == nil {
+		lock = &volumeLock{}
+		v.locks[name] = lock
+	}
+	lock.refs++
+	v.lock.Unlock()
+
+	if read {
+		lock.RLock()
+	} else {
+		lock.Lock()
+	}
+
+	return func() {
+		if read {
+			lock.RUnlock()
+		} else {
+			lock.Unlock()
+		}
+
+		v.lock.Lock()
+		lock.refs--
+		if lock.refs == 0 {
+			delete(v.locks, name)
+		}
+		v.lock.Unlock()
+	}
+}
+
+// driverLocks are the driver lock and the volume locks of a driver, ordered as described above
+type driverLocks struct {
+	lock        *sync.RWMutex
+	volumeLocks *volumeLocks
+}
+
+func newDriverLocks() driverLocks {
+	return driverLocks{lock: &sync.RWMutex{}, volumeLocks: newVolumeLocks()}
+}
+
+// lockVolume takes lock shared and then the lock of volume name, shared when read is set,
+// and returns the function releasing both
+func (d *driverLocks) lockVolume(name string, read bool) func() {
+	d.lock.RLock()
+	release := d.volumeLocks.acquire(name, read)
+
+	return func() {
+		release()
+		d.lock.RUnlock()
+	}
+}
+
+// lockVolumeTree locks volume name for a create or remove. Nested volumes share parent directories and are checked
+// against each other, so with allowNestedNames they are created and removed under the exclusive driver lock.
+func (n *nfs) lockVolumeTree(name string) func() {
+	if !n.opts.AllowNestedNames {
+		return n.lockVolume(name, false)
+	}
+
+	n.lock.Lock()
+	return n.lock.Unlock
+}
@@ -0,0 +1,105 @@
+package drivers
+
+import (
+	"docker-volume-plugin/pkg/drivers/store"
+	"docker-volume-plugin/pkg/drivers/store/badger"
+	"docker-volume-plugin/pkg/drivers/store/jsonfile"
+	"docker-volume-plugin/pkg/log"
+	"fmt"
+	"os"
+	"path"
+)
+
+// Metadata stores selected by the metadataStore driver option
+const (
+	metadataStoreBadger   = "badger"
+	metadataStoreJSONFile = "jsonfile"
+)
+
+// metadataFiles of each metadata store, kept in the metadata directory of the share
+var metadataFiles = map[string]string{
+	metadataStoreBadger:   "metadata.db",
+	metadataStoreJSONFile: "metadata.json",
+}
+
+// metadataReservedPath returns the paths on the share used by the metadata store of kind, which volumes can not use.
+// The badger paths stay reserved with any store, as a database left by an earlier store may still be there.
+func metadataReservedPath(kind string) []string {
+	reservedPath := []string{"metadata.db", "metadata.db.lock"}
+	if kind != metadataStoreBadger {
+		reservedPath = append(reservedPath, metadataFiles[kind], metadataFiles[kind]+".lock")
+	}
+	return reservedPath
+}
+
+// validateMetadataStore checks that kind is a supported metadata store
+func validateMetadataStore(kind string) error {
+	_, ok := metadataFiles[kind]
+	if !ok {
+		return fmt.Errorf("unsupported metadataStore %s, must be %s or %s", kind, metadataStoreBadger, metadataStoreJSONFile)
+	}
+	return nil
+}
+
+// newMetadataStore opens the metadata store of kind in dir, locked with the file at lockPath, or next to the metadata
+// when lockPath is empty. badger is recovered with truncate, while jsonfile imports the content of a badger database
+// in dir once when its own file does not exist yet, leaving the database untouched.
+func newMetadataStore(logger *log.Logger, kind string, dir string, lockPath string, truncate bool) (store.Store, error) {
+	badgerLockPath := lockPath
+	if badgerLockPath == "" {
+		badgerLockPath = path.Join(dir, "metadata.db.lock")
+	}
+	newBadgerDB := func() *badger.DB {
+		return badger.NewBadgerDB(logger.WithService("badger").WithLogLevel(log.WarnLevel), path.Join(dir, "metadata.db"), badgerLockPath)
+	}
+
+	switch kind {
+	case metadataStoreBadger:
+		db := newBadgerDB()
+		err := db.Recover(truncate)
+		if err != nil {
+			return nil, fmt.Errorf("failed to recover metadata: %v", err)
+		}
+		return db, nil
+	case metadataStoreJSONFile:
+		if lockPath == "" {
+			lockPath = path.Join(dir, "metadata.json.lock")
+		}
+		db := jsonfile.NewJSONFileDB(logger.WithService("jsonfile").WithLogLevel(log.WarnLevel), path.Join(dir, "metadata.json"), lockPath)
+
+		_, err := os.Stat(path.Join(dir, "metadata.json"))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to stat metadata file: %v", err)
+		}
+		_, badgerErr := os.Stat(path.Join(dir, "metadata.db"))
+		if err == nil || badgerErr != nil {
+			// Writes an empty document at first start, so the file is there for the health check to probe
+			_, err = db.Import(nil, nil)
+			if err != nil {
+				return nil, fmt.Errorf("failed to initialize metadata file: %v", err)
+			}
+			return db, nil
+		}
+
+		legacy := newBadgerDB()
+		volumeMetadataMap, historyMap, err := legacy.Export()
+		closeErr := legacy.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to export badger metadata for import: %v", err)
+		}
+		if closeErr != nil {
+			logger.Warningf("failed to close badger metadata after export: %v", closeErr)
+		}
+
+		imported, err := db.Import(volumeMetadataMap, historyMap)
+		if err != nil {
+			return nil, fmt.Errorf("failed to import badger metadata: %v", err)
+		}
+		if imported {
+			logger.Infof("imported %d volumes from badger metadata %s, which is left untouched", len(volumeMetadataMap), path.Join(dir, "metadata.db"))
+		}
+		return db, nil
+	default:
+		return nil, fmt.Errorf("unsupported metadataStore %s, must be %s or %s", kind, metadataStoreBadger, metadataStoreJSONFile)
+	}
+}
@@ -0,0 +1,285 @@
+package drivers
+
+import (
+	"context"
+	"docker-volume-plugin/pkg/drivers/apis"
+	"docker-volume-plugin/pkg/log"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metricsOptions are the driver options of the metrics of any driver
+type metricsOptions struct {
+	// MetricsAddress is the address serving the metrics on /metrics, empty disables the metrics
+	MetricsAddress string `json:"metricsAddress,omitempty"`
+	// MetricsRefreshInterval is the interval between refreshes of the volume inventory gauges
+	MetricsRefreshInterval string `json:"metricsRefreshInterval,omitempty"`
+}
+
+// volumeCounter is implemented by drivers counting their volumes from the metadata alone, without the usage, orphan
+// and warmup work of List
+type volumeCounter interface {
+	// volumeCounts returns the number of volumes and of volumes mounted at least once
+	volumeCounts() (int, int, error)
+}
+
+// metricsDriver records the operations of the wrapped driver and serves them with the volume inventory as
+// Prometheus metrics
+type metricsDriver struct {
+	apis.Driver
+	logger     *log.Logger
+	name       string
+	operations *prometheus.CounterVec
+	durations  *prometheus.HistogramVec
+	volumes    prometheus.Gauge
+	mounted    prometheus.Gauge
+	server     *http.Server
+	// stopRefresh stops the refresh of the gauges, which closes refreshDone once it returned
+	stopRefresh context.CancelFunc
+	refreshDone chan struct{}
+	destroyOnce sync.Once
+	destroyErr  error
+}
+
+// withMetrics wraps driver name with metrics when driverOptions set metricsAddress, and returns it as is otherwise
+func withMetrics(ctx context.Context, logger *log.Logger, name string, driver apis.Driver, driverOptions string) (apis.Driver, error) {
+	opts := &metricsOptions{
+		MetricsRefreshInterval: "15s",
+	}
+	err := json.Unmarshal([]byte(driverOptions), opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse driver options: %v", err)
+	}
+	if opts.MetricsAddress == "" {
+		return driver, nil
+	}
+
+	refreshInterval, err := time.ParseDuration(opts.MetricsRefreshInterval)
+	if err != nil || refreshInterval <= 0 {
+		return nil, fmt.Errorf("invalid metricsRefreshInterval: must be a positive duration")
+	}
+
+	listener, err := net.Listen("tcp", opts.MetricsAddress)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on metricsAddress %s: %v", opts.MetricsAddress, err)
+	}
+
+	labels := prometheus.Labels{"driver": name}
+	m := &metricsDriver{
+		Driver: driver,
+		logger: logger,
+		name:   name,
+		operations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name:        name + "_driver_operations_total",
+			Help:        "Number of volume operations by operation and result.",
+			ConstLabels: labels,
+		}, []string{"operation", "result"}),
+		durations: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:        name + "_driver_operation_duration_seconds",
+			Help:        "Duration of volume operations by operation and result.",
+			ConstLabels: labels,
+			Buckets:     prometheus.DefBuckets,
+		}, []string{"operation", "result"}),
+		volumes: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name:        name + "_driver_volumes",
+			Help:        "Number of volumes, refreshed every metricsRefreshInterval.",
+			ConstLabels: labels,
+		}),
+		mounted: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name:        name + "_driver_mounted_volumes",
+			Help:        "Number of volumes mounted at least once, refreshed every metricsRefreshInterval.",
+			ConstLabels: labels,
+		}),
+	}
+
+	// Every driver has its own registry, so several drivers in one process do not clash
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(m.operations, m.durations)
+	counter, ok := driver.(volumeCounter)
+	if ok {
+		registry.MustRegister(m.volumes, m.mounted)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	m.server = &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		err := m.server.Serve(listener)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Errorf("failed to serve metrics on %s: %v", opts.MetricsAddress, err)
+		}
+	}()
+
+	if ok {
+		refreshCtx, cancel := context.WithCancel(ctx)
+		m.stopRefresh = cancel
+		m.refreshDone = make(chan struct{})
+		go m.refresh(refreshCtx, counter, refreshInterval)
+	}
+
+	logger.Infof("serve metrics on %s", listener.Addr())
+	return m, nil
+}
+
+// refresh updates the volume inventory gauges from counter every interval until ctx is done. Scrapes read the gauges
+// only, so they never wait for the driver.
+func (m *metricsDriver) refresh(ctx context.Context, counter volumeCounter, interval time.Duration) {
+	defer close(m.refreshDone)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		volumes, mounted, err := counter.volumeCounts()
+		if err != nil {
+			m.logger.Debugf("failed to count volumes for metrics: %v", err)
+		} else {
+			m.volumes.Set(float64(volumes))
+			m.mounted.Set(float64(mounted))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// countVolumes returns the number of volumes in volumeMetadataMap and of those mounted at least once, skipping the
+// entries List skips as invalid names
+func countVolumes(volumeMetadataMap map[string]*apis.VolumeMetadata, validateVolumeName func(name string) error) (int, int) {
+	volumes, mounted := 0, 0
+	for name, volumeMetadata := range volumeMetadataMap {
+		if validateVolumeName(name) != nil {
+			continue
+		}
+		volumes++
+		if volumeMetadata.Status != nil && len(volumeMetadata.Status.MountBy) != 0 {
+			mounted++
+		}
+	}
+	return volumes, mounted
+}
+
+func (n *nfs) volumeCounts() (int, int, error) {
+	n.lock.RLock()
+	defer n.lock.RUnlock()
+
+	if n.closed {
+		return 0, 0, apis.ErrShuttingDown
+	}
+
+	err := n.checkBackend()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	volumeMetadataMap, err := n.db.GetVolumeMetadataMap()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	volumes, mounted := countVolumes(volumeMetadataMap, n.validateVolumeName)
+	return volumes, mounted, nil
+}
+
+func (c *cifs) volumeCounts() (int, int, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	if c.closed {
+		return 0, 0, apis.ErrShuttingDown
+	}
+
+	volumeMetadataMap, err := c.db.GetVolumeMetadataMap()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	volumes, mounted := countVolumes(volumeMetadataMap, c.validateVolumeName)
+	return volumes, mounted, nil
+}
+
+// observe records an operation that started at start and returned err
+func (m *metricsDriver) observe(operation string, start time.Time, err error) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	m.operations.WithLabelValues(operation, result).Inc()
+	m.durations.WithLabelValues(operation, result).Observe(time.Since(start).Seconds())
+}
+
+func (m *metricsDriver) Create(name string, options map[string]string) (err error) {
+	defer func(start time.Time) { m.observe("create", start, err) }(time.Now())
+	return m.Driver.Create(name, options)
+}
+
+func (m *metricsDriver) List() (volumeMetadataMap map[string]*apis.VolumeMetadata, err error) {
+	defer func(start time.Time) { m.observe("list", start, err) }(time.Now())
+	return m.Driver.List()
+}
+
+func (m *metricsDriver) Get(name string) (volumeMetadata *apis.VolumeMetadata, err error) {
+	defer func(start time.Time) { m.observe("get", start, err) }(time.Now())
+	return m.Driver.Get(name)
+}
+
+func (m *metricsDriver) Remove(name string) (err error) {
+	defer func(start time.Time) { m.observe("remove", start, err) }(time.Now())
+	return m.Driver.Remove(name)
+}
+
+// ForceRemove forwards to the wrapped driver, which wrapping must not hide
+func (m *metricsDriver) ForceRemove(name string) (err error) {
+	defer func(start time.Time) { m.observe("remove", start, err) }(time.Now())
+
+	forceRemover, ok := m.Driver.(apis.ForceRemover)
+	if !ok {
+		return fmt.Errorf("driver %s does not support forced removal", m.name)
+	}
+	return forceRemover.ForceRemove(name)
+}
+
+func (m *metricsDriver) Path(name string) (mountpoint string, err error) {
+	defer func(start time.Time) { m.observe("path", start, err) }(time.Now())
+	return m.Driver.Path(name)
+}
+
+func (m *metricsDriver) Mount(name string, id string) (mountpoint string, err error) {
+	defer func(start time.Time) { m.observe("mount", start, err) }(time.Now())
+	return m.Driver.Mount(name, id)
+}
+
+func (m *metricsDriver) Unmount(name string, id string) (err error) {
+	defer func(start time.Time) { m.observe("unmount", start, err) }(time.Now())
+	return m.Driver.Unmount(name, id)
+}
+
+func (m *metricsDriver) Destroy() error {
+	m.destroyOnce.Do(func() {
+		if m.stopRefresh != nil {
+			m.stopRefresh()
+			<-m.refreshDone
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		err := m.server.Shutdown(ctx)
+		if err != nil {
+			m.logger.Warningf("failed to shut down metrics server: %v", err)
+		}
+
+		m.destroyErr = m.Driver.Destroy()
+	})
+	return m.destroyErr
+}
@@ -3,16 +3,24 @@ package drivers
 import (
 	"context"
 	"docker-volume-plugin/pkg/drivers/apis"
+	"docker-volume-plugin/pkg/drivers/store"
 	"docker-volume-plugin/pkg/drivers/store/badger"
 	"docker-volume-plugin/pkg/log"
 	"docker-volume-plugin/pkg/utils"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"maps"
+	"net"
 	"os"
 	"path"
+	"path/filepath"
 	"slices"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 )
 
@@ -20,41 +28,266 @@ func init() {
 	registerFactory("nfs", nfsFactory)
 }
 
-func nfsFactory(ctx context.Context, logger *log.Logger, propagatedMountpoint string, driverOptions string) (apis.Driver, error) {
+func nfsFactory(ctx context.Context, logger *log.Logger, propagatedMountpoint string, driverOptions string) (_ apis.Driver, err error) {
 	opts := &nfsOptions{
-		PurgeAfterDelete: false,
-		MountOptions:     []string{"nfsvers=4", "rw", "noatime", "rsize=8192", "wsize=8192", "tcp", "timeo=14", "sync"},
+		PurgeAfterDelete:           false,
+		TruncateMetadataOnRecovery: true,
+		PurgeRetries:               3,
+		PurgeRetryInterval:         "1s",
+		WarmupMaxBytes:             1 << 30,
+		WarmupTimeout:              "10m",
+		HistoryDepth:               20,
+		UsageTTL:                   "30s",
+		HealthCheckInterval:        "30s",
+		HealthCheckMaxBackoff:      "5m",
+		HealthCheckTimeout:         "10s",
+		MetadataStore:              metadataStoreBadger,
+		ClaimTTL:                   "2m",
 	}
-	err := json.Unmarshal([]byte(driverOptions), opts)
+	err = json.Unmarshal([]byte(driverOptions), opts)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse driver options: %v", err)
 	}
 
-	// Mount NFS share to a local mount point
+	err = validateRemotePath(opts.RemotePath)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.MountOptions == nil {
+		opts.MountOptions, err = defaultNFSMountOptions(opts.NFSVersion)
+		if err != nil {
+			return nil, err
+		}
+	} else if opts.NFSVersion != "" {
+		opts.MountOptions = utils.MergeMountOptions([]string{"nfsvers=" + opts.NFSVersion}, opts.MountOptions)
+	}
+	opts.MountOptions = utils.MergeMountOptions(opts.MountOptions)
+
+	remotePath, err := resolveRemotePath(opts.RemotePath, opts.PseudoRoot, opts.MountOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.MountBackground {
+		opts.MountOptions = backgroundMountOptions(opts.MountOptions)
+	}
+
+	var webhook *mountWebhook
+	if opts.MountWebhook != nil {
+		webhook, err = newMountWebhook(logger.WithService("mount-webhook"), opts.MountWebhook)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	purgeRetryInterval, err := time.ParseDuration(opts.PurgeRetryInterval)
+	if err != nil {
+		return nil, fmt.Errorf("invalid purgeRetryInterval: %v", err)
+	}
+
+	warmupTimeout, err := time.ParseDuration(opts.WarmupTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("invalid warmupTimeout: %v", err)
+	}
+
+	usageTTL, err := time.ParseDuration(opts.UsageTTL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid usageTTL: %v", err)
+	}
+
+	healthCheckInterval, err := time.ParseDuration(opts.HealthCheckInterval)
+	if err != nil {
+		return nil, fmt.Errorf("invalid healthCheckInterval: %v", err)
+	}
+
+	healthCheckMaxBackoff, err := time.ParseDuration(opts.HealthCheckMaxBackoff)
+	if err != nil {
+		return nil, fmt.Errorf("invalid healthCheckMaxBackoff: %v", err)
+	}
+
+	healthCheckTimeout, err := time.ParseDuration(opts.HealthCheckTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("invalid healthCheckTimeout: %v", err)
+	}
+	if healthCheckTimeout <= 0 {
+		return nil, fmt.Errorf("invalid healthCheckTimeout: must be a positive duration")
+	}
+
+	claimTTL, err := time.ParseDuration(opts.ClaimTTL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid claimTTL: %v", err)
+	}
+
+	if opts.NodeID == "" {
+		opts.NodeID, err = os.Hostname()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get hostname as nodeID, set nodeID instead: %v", err)
+		}
+	}
+	err = validateNodeID(opts.NodeID)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.MetadataLockPath != "" {
+		err = os.MkdirAll(path.Dir(opts.MetadataLockPath), 0755)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create metadata lock directory: %v", err)
+		}
+	}
+
+	err = validateMetadataStore(opts.MetadataStore)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.MetadataReplicaPath != "" && opts.MetadataStore != metadataStoreBadger {
+		return nil, fmt.Errorf("metadataReplicaPath is only supported by the %s metadata store", metadataStoreBadger)
+	}
+
+	// Everything mounted and opened below is released again when the factory fails, so the next start does not mount
+	// on top of it
+	var mountpoints []string
+	var db store.Store
+	defer func() {
+		if err == nil {
+			return
+		}
+		if db != nil {
+			closeErr := db.Close()
+			if closeErr != nil {
+				logger.Warningf("failed to close metadata: %v", closeErr)
+			}
+		}
+		for _, mountpoint := range slices.Backward(mountpoints) {
+			umountErr := utils.Umount(mountpoint)
+			if umountErr != nil {
+				logger.Warningf("failed to unmount NFS share on %s: %v", mountpoint, umountErr)
+			}
+		}
+	}()
+
+	// Mount NFS share to a local mount point only once every option is valid, so a mistake in them leaves nothing mounted
 	err = os.MkdirAll(propagatedMountpoint, 0755)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create NFS mount point directory: %v", err)
 	}
 
 	if opts.Address != "nfs-server.mock" {
-		err = utils.MountNFS(opts.Address, opts.RemotePath, propagatedMountpoint, opts.MountOptions)
+		err = utils.MountNFS(ctx, opts.Address, remotePath, propagatedMountpoint, opts.MountOptions)
 		if err != nil {
 			return nil, fmt.Errorf("failed to mount NFS share: %v", err)
 		}
+		mountpoints = append(mountpoints, propagatedMountpoint)
+	}
+
+	if opts.SkipWriteProbe {
+		logger.Info("skip write probe of NFS share")
+	} else if opts.MountBackground && opts.Address != "nfs-server.mock" {
+		logger.Info("skip write probe of NFS share mounted in background")
+	} else {
+		err = writeProbe(propagatedMountpoint)
+		if err != nil {
+			return nil, fmt.Errorf("NFS share on %s is not writable with the current credentials, set skipWriteProbe for read-only deployments: %v", propagatedMountpoint, err)
+		}
+	}
+
+	// Metadata is kept on a separate mount of the share without attribute and lookup caching when strict coherency is required
+	metadataMountpoint := propagatedMountpoint
+	if opts.StrictMetadataCoherency && opts.Address != "nfs-server.mock" {
+		metadataMountpoint = propagatedMountpoint + "-metadata"
+		err = os.MkdirAll(metadataMountpoint, 0755)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create NFS metadata mount point directory: %v", err)
+		}
+
+		err = utils.MountNFS(ctx, opts.Address, remotePath, metadataMountpoint, coherentMountOptions(opts.MountOptions))
+		if err != nil {
+			return nil, fmt.Errorf("failed to mount NFS share for metadata: %v", err)
+		}
+		mountpoints = append(mountpoints, metadataMountpoint)
+	}
+
+	db, err = newMetadataStore(logger, opts.MetadataStore, metadataMountpoint, opts.MetadataLockPath, opts.TruncateMetadataOnRecovery)
+	if err != nil {
+		return nil, err
+	}
+
+	driver := &nfs{
+		logger:                logger,
+		opts:                  opts,
+		webhook:               webhook,
+		warmupTimeout:         warmupTimeout,
+		usageTTL:              usageTTL,
+		remotePath:            remotePath,
+		healthCheckInterval:   healthCheckInterval,
+		healthCheckMaxBackoff: healthCheckMaxBackoff,
+		healthCheckTimeout:    healthCheckTimeout,
+		purgeRetryInterval:    purgeRetryInterval,
+		nodeID:                opts.NodeID,
+		claimTTL:              claimTTL,
+		warmups:               map[string]*warmup{},
+		warmupsLock:           &sync.Mutex{},
+		clones:                map[string]*volumeClone{},
+		clonesLock:            &sync.Mutex{},
+		pendingUnmounts:       map[string][]string{},
+		pendingUnmountsLock:   &sync.Mutex{},
+		db:                    db,
+		metadataFile:          metadataFiles[opts.MetadataStore],
+		rootPath:              propagatedMountpoint,
+		metadataPath:          metadataMountpoint,
+		nameMax:               utils.NameMax(propagatedMountpoint),
+		driverLocks:           newDriverLocks(),
+		reservedPath:          append(metadataReservedPath(opts.MetadataStore), nodeLeasesPath),
+	}
+
+	if opts.MetadataReplicaPath != "" {
+		err = db.(*badger.DB).EnableReplica(opts.MetadataReplicaPath, opts.PromoteMetadataReplica)
+		if err != nil {
+			return nil, fmt.Errorf("failed to enable metadata replica: %v", err)
+		}
+	}
+
+	// The lease is written even without a heartbeat, so other nodes can tell this node ever started. Without it the
+	// mounts of this node could be taken over, so the driver does not start. A share still mounting in background gets
+	// the lease from the heartbeat once mounted.
+	if driver.checkBackend() == nil {
+		err = driver.renewLease()
+		if err != nil {
+			return nil, err
+		}
+
+		driver.failInterruptedClones()
+		if claimTTL > 0 {
+			driver.stampLegacyClaims()
+		}
+	}
+
+	driver.refreshServerIdentity()
+
+	if healthCheckInterval > 0 && opts.Address != "nfs-server.mock" {
+		healthCheckCtx, cancel := context.WithCancel(ctx)
+		driver.stopHealthCheck = cancel
+		driver.healthCheckDone = make(chan struct{})
+		go driver.healthCheck(healthCheckCtx)
 	}
 
-	return &nfs{
-		logger: logger,
-		opts:   opts,
-		db: badger.NewBadgerDB(
-			logger.WithService("badger").WithLogLevel(log.WarnLevel),
-			path.Join(propagatedMountpoint, "metadata.db"),
-			path.Join(propagatedMountpoint, "metadata.db.lock"),
-		),
-		rootPath:     propagatedMountpoint,
-		lock:         &sync.RWMutex{},
-		reservedPath: []string{"metadata.db", "metadata.db.lock"},
-	}, nil
+	if claimTTL > 0 {
+		heartbeatCtx, cancel := context.WithCancel(ctx)
+		driver.stopHeartbeat = cancel
+		driver.heartbeatDone = make(chan struct{})
+		go driver.heartbeat(heartbeatCtx)
+	}
+
+	if usageTTL > 0 {
+		usageCheckCtx, cancel := context.WithCancel(ctx)
+		driver.stopUsageCheck = cancel
+		driver.usageCheckDone = make(chan struct{})
+		go driver.usageCheck(usageCheckCtx)
+	}
+
+	return driver, nil
 }
 
 type nfsOptions struct {
@@ -62,157 +295,1253 @@ type nfsOptions struct {
 	Address string `json:"address"`
 	// RemotePath of NFS exported
 	RemotePath string `json:"remotePath"`
-	// MountOptions for NFS
+	// PseudoRoot is the server side path of the NFSv4 pseudo filesystem root (the fsid=0 export)
+	PseudoRoot string `json:"pseudoRoot,omitempty"`
+	// NFSVersion selects the default mount options, and is added to mountOptions unless they set a version
+	NFSVersion string `json:"nfsVersion,omitempty"`
+	// MountOptions for NFS, default to the options tuned for NFSVersion
 	MountOptions []string `json:"mountOptions,omitempty"`
+	// MountBackground indicates whether to mount with bg so a failed first attempt is retried in the background
+	MountBackground bool `json:"mountBackground,omitempty"`
+	// SkipWriteProbe indicates whether to skip verifying that the mounted share is writable
+	SkipWriteProbe bool `json:"skipWriteProbe,omitempty"`
 	// PurgeAfterDelete indicates whether to purge the volume data after deletion
 	PurgeAfterDelete bool `json:"purgeAfterDelete,omitempty"`
+	// PurgeRetries is the number of attempts to purge the volume data on transient errors
+	PurgeRetries int `json:"purgeRetries,omitempty"`
+	// PurgeRetryInterval is the interval between attempts to purge the volume data
+	PurgeRetryInterval string `json:"purgeRetryInterval,omitempty"`
+	// WarmupOnMount indicates whether to prefetch the volume data into the page cache after mount
+	WarmupOnMount bool `json:"warmupOnMount,omitempty"`
+	// WarmupMaxBytes is the maximum number of bytes read by a warmup
+	WarmupMaxBytes int64 `json:"warmupMaxBytes,omitempty"`
+	// WarmupTimeout is the maximum duration of a warmup
+	WarmupTimeout string `json:"warmupTimeout,omitempty"`
+	// StrictMetadataCoherency indicates whether to access metadata through a separate mount without client side caching
+	StrictMetadataCoherency bool `json:"strictMetadataCoherency,omitempty"`
+	// TruncateMetadataOnRecovery indicates whether to truncate the metadata logs after an unclean shutdown
+	TruncateMetadataOnRecovery bool `json:"truncateMetadataOnRecovery"`
+	// MetadataStore selects the backend keeping the metadata, badger or jsonfile
+	MetadataStore string `json:"metadataStore,omitempty"`
+	// MetadataLockPath overrides the path of the metadata lock file, which defaults to a lock file next to the metadata on the share
+	MetadataLockPath string `json:"metadataLockPath,omitempty"`
+	// MetadataReplicaPath is a file, ideally on another mount, kept as a warm standby copy of the metadata
+	MetadataReplicaPath string `json:"metadataReplicaPath,omitempty"`
+	// PromoteMetadataReplica indicates whether to replace the metadata with the replica at startup when the replica is newer
+	PromoteMetadataReplica bool `json:"promoteMetadataReplica,omitempty"`
+	// AllowNestedNames indicates whether volume names may contain / to map to nested directories on the share
+	AllowNestedNames bool `json:"allowNestedNames,omitempty"`
+	// AdoptOrphans indicates whether List registers volume directories found without metadata as volumes
+	AdoptOrphans bool `json:"adoptOrphans,omitempty"`
+	// IgnoredVolumeOptions are volume option keys injected by the caller that are accepted and ignored
+	IgnoredVolumeOptions []string `json:"ignoredVolumeOptions,omitempty"`
+	// HealthCheckInterval is the interval between checks that the NFS share is still mounted, 0 disables the checks
+	HealthCheckInterval string `json:"healthCheckInterval,omitempty"`
+	// HealthCheckMaxBackoff is the maximum interval between attempts to remount an unhealthy NFS share
+	HealthCheckMaxBackoff string `json:"healthCheckMaxBackoff,omitempty"`
+	// HealthCheckTimeout is how long a check waits for the NFS share to answer before it is considered hung
+	HealthCheckTimeout string `json:"healthCheckTimeout,omitempty"`
+	// UsageTTL is how long the disk usage of a volume is cached before it is computed again,
+	// volumes with a size are also checked in background at this interval
+	UsageTTL string `json:"usageTTL,omitempty"`
+	// HistoryDepth is the number of change history entries kept per volume, 0 disables the history
+	HistoryDepth int `json:"historyDepth"`
+	// NodeID identifies this node in the mounts it holds on volumes shared with other nodes, defaults to the hostname
+	NodeID string `json:"nodeID,omitempty"`
+	// ClaimTTL is how long the mounts of a node whose lease is no longer renewed are kept before they can be taken
+	// over, 0 disables the heartbeat and the takeover
+	ClaimTTL string `json:"claimTTL,omitempty"`
+	// MountWebhook is called before every mount to approve or deny it
+	MountWebhook *mountWebhookOptions `json:"mountWebhook,omitempty"`
 }
 
-type nfs struct {
-	logger       *log.Logger
-	opts         *nfsOptions
-	db           *badger.DB
-	rootPath     string
-	lock         *sync.RWMutex
-	reservedPath []string
+// defaultMountOptions by major NFS version
+var defaultMountOptions = map[string][]string{
+	"3": {"nfsvers=3", "rw", "noatime", "rsize=32768", "wsize=32768", "tcp", "timeo=600", "retrans=2", "hard", "sync"},
+	"4": {"nfsvers=4", "rw", "noatime", "rsize=8192", "wsize=8192", "tcp", "timeo=14", "sync"},
 }
 
-func (n *nfs) Create(name string, options map[string]string) (err error) {
-	n.lock.Lock()
-	defer n.lock.Unlock()
+// defaultNFSMountOptions returns the default mount options for version, which defaults to 4
+func defaultNFSMountOptions(version string) ([]string, error) {
+	switch {
+	case version == "" || version == "4":
+		return slices.Clone(defaultMountOptions["4"]), nil
+	case version == "3":
+		return slices.Clone(defaultMountOptions["3"]), nil
+	case strings.HasPrefix(version, "4."):
+		return utils.MergeMountOptions(defaultMountOptions["4"], []string{"nfsvers=" + version}), nil
+	default:
+		return nil, fmt.Errorf("unsupported NFS version %s", version)
+	}
+}
 
-	if slices.Contains(n.reservedPath, name) {
-		return fmt.Errorf("volume name %s is reserved, please choose a different name", name)
+// validateRemotePath rejects remote paths that would make the mount source malformed or fall back to the server root
+func validateRemotePath(remotePath string) error {
+	if remotePath == "" {
+		return fmt.Errorf("remotePath is required")
 	}
+	if !strings.HasPrefix(remotePath, "/") {
+		return fmt.Errorf("invalid remotePath %s: must be an absolute path on the server", remotePath)
+	}
+	return nil
+}
 
-	purgeAfterDelete := n.opts.PurgeAfterDelete
-	for key, value := range options {
-		switch key {
-		case "purgeAfterDelete":
-			purgeAfterDelete, err = strconv.ParseBool(value)
-			if err != nil {
-				return fmt.Errorf("invalid value for purgeAfterDelete: %v", err)
-			}
-		default:
-			return fmt.Errorf("unknown option %s with value %s, ignoring", key, value)
-		}
+// resolveRemotePath returns the path to mount for the NFS version in mount options.
+// NFSv4 resolves paths against the pseudo filesystem root so the pseudo root prefix is stripped,
+// while NFSv3 mounts the export path as is. A negotiated version is treated as NFSv4.
+func resolveRemotePath(remotePath string, pseudoRoot string, mountOptions []string) (string, error) {
+	if strings.HasPrefix(utils.NFSVersion(mountOptions), "3") || pseudoRoot == "" {
+		return remotePath, nil
 	}
 
-	n.logger.Infof("create volume %s", name)
+	remotePath = path.Clean(remotePath)
+	pseudoRoot = path.Clean(pseudoRoot)
+	switch {
+	case pseudoRoot == "/":
+		return remotePath, nil
+	case remotePath == pseudoRoot:
+		return "/", nil
+	case strings.HasPrefix(remotePath, pseudoRoot+"/"):
+		return strings.TrimPrefix(remotePath, pseudoRoot), nil
+	default:
+		return "", fmt.Errorf("remote path %s is outside of NFSv4 pseudo root %s", remotePath, pseudoRoot)
+	}
+}
 
-	return n.db.CreateVolumeMetadata(name, func(volumeMetadata *apis.VolumeMetadata) error {
-		*volumeMetadata = apis.VolumeMetadata{
-			Mountpoint: path.Join(name, "_data"),
-			CreatedAt:  time.Now(),
-			Spec: &apis.VolumeSpec{
-				PurgeAfterDelete: purgeAfterDelete,
-			},
-			Status: &apis.VolumeStatus{
-				MountBy: "",
-			},
-		}
+// writeProbe verifies that dir is writable by creating and deleting a temporary file in it
+func writeProbe(dir string) error {
+	file, err := os.CreateTemp(dir, ".write-probe-*")
+	if err != nil {
+		return err
+	}
 
-		return os.MkdirAll(path.Join(n.rootPath, volumeMetadata.Mountpoint), 0755)
-	},
-	)
+	_, err = file.Write([]byte("probe"))
+	closeErr := file.Close()
+	removeErr := os.Remove(file.Name())
+	return errors.Join(err, closeErr, removeErr)
 }
 
-func (n *nfs) List() (map[string]*apis.VolumeMetadata, error) {
-	n.lock.Lock()
-	defer n.lock.Unlock()
-
-	n.logger.Info("list volumes")
+// backgroundMountOptions replaces fg with bg in mountOptions
+func backgroundMountOptions(mountOptions []string) []string {
+	return utils.MergeMountOptions(mountOptions, []string{"bg"})
+}
 
-	return n.db.GetVolumeMetadataMap()
+// coherentMountOptions disables attribute and lookup caching in mountOptions,
+// and keeps the mount from sharing its cache with other mounts of the same export
+func coherentMountOptions(mountOptions []string) []string {
+	cacheKeys := []string{"ac", "noac", "actimeo", "acregmin", "acregmax", "acdirmin", "acdirmax", "lookupcache", "sharecache", "nosharecache"}
+	options := slices.DeleteFunc(slices.Clone(mountOptions), func(option string) bool {
+		key, _, _ := strings.Cut(option, "=")
+		return slices.Contains(cacheKeys, key)
+	})
+	return utils.MergeMountOptions(options, []string{"noac", "lookupcache=none", "nosharecache"})
 }
 
-func (n *nfs) Get(name string) (*apis.VolumeMetadata, error) {
-	n.lock.Lock()
-	defer n.lock.Unlock()
+// maxCreatedAtSkew is how far in the future a createdAt option may be, to tolerate clock skew between hosts
+const maxCreatedAtSkew = 5 * time.Minute
 
-	n.logger.Infof("get volume %s", name)
+// parseCreatedAt parses the createdAt option used to preserve the creation time of imported volumes
+func parseCreatedAt(value string) (time.Time, error) {
+	createdAt, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return createdAt, fmt.Errorf("invalid value for createdAt: %v", err)
+	}
+
+	if createdAt.After(time.Now().Add(maxCreatedAtSkew)) {
+		return createdAt, fmt.Errorf("invalid value for createdAt: %s is in the future", value)
+	}
 
-	return n.db.GetVolumeMetadata(name)
+	return createdAt, nil
 }
 
-func (n *nfs) Remove(name string) error {
-	n.lock.Lock()
-	defer n.lock.Unlock()
+// backendError maps errors caused by a full backend to ErrBackendFull so callers can back off instead of retrying
+func backendError(err error) error {
+	if errors.Is(err, syscall.ENOSPC) || errors.Is(err, syscall.EDQUOT) {
+		return fmt.Errorf("%w: %v", apis.ErrBackendFull, err)
+	}
+	return err
+}
 
-	n.logger.Infof("remove volume %s", name)
-	return n.db.DeleteVolumeMetadata(name, func(volumeMetadata *apis.VolumeMetadata) error {
-		if len(volumeMetadata.Status.MountBy) != 0 {
-			return fmt.Errorf("volume %s is mounted by %s, unmount it before removing", name, volumeMetadata.Status.MountBy)
+// checkDataPath returns an error if the data path of a volume is not a directory.
+// A missing data path is accepted only when allowMissing is set.
+func checkDataPath(dataPath string, allowMissing bool) error {
+	info, err := os.Stat(dataPath)
+	if err != nil {
+		if os.IsNotExist(err) && allowMissing {
+			return nil
 		}
+		return fmt.Errorf("failed to stat volume data path %s: %v", dataPath, err)
+	}
 
-		if volumeMetadata.Spec.PurgeAfterDelete {
-			err := os.RemoveAll(path.Join(n.rootPath, name))
-			if err != nil {
-				return fmt.Errorf("failed to remove volume data: %v", err)
-			}
-		}
-		return nil
-	})
+	if !info.IsDir() {
+		return fmt.Errorf("volume data path %s exists but is not a directory (mode %s), remove or rename it", dataPath, info.Mode().Type())
+	}
+
+	return nil
 }
 
-func (n *nfs) Path(name string) (string, error) {
-	n.lock.Lock()
-	defer n.lock.Unlock()
+// nfsBackendData is the nfs specific data stored in the volume metadata
+type nfsBackendData struct {
+	// Export the volume was created on
+	Export string `json:"export"`
+}
 
-	n.logger.Infof("path volume %s", name)
+type nfs struct {
+	logger       *log.Logger
+	opts         *nfsOptions
+	webhook      *mountWebhook
+	db           store.Store
+	rootPath     string
+	metadataPath string
+	metadataFile string
+	nameMax      int
+	reservedPath []string
+	// driverLocks serialize the operations on the driver and its volumes, see locks.go for the lock ordering
+	driverLocks
+	// closed is set by Destroy, guarded by lock
+	closed bool
+	// serverIdentity of the server backing the mount, replaced by the remount of the health check
+	serverIdentity     atomic.Pointer[map[string]interface{}]
+	warmupTimeout      time.Duration
+	usageTTL           time.Duration
+	purgeRetryInterval time.Duration
+	// warmups in progress by volume name, guarded by warmupsLock
+	warmups     map[string]*warmup
+	warmupsLock *sync.Mutex
+	// clones in progress by volume name, guarded by clonesLock
+	clones     map[string]*volumeClone
+	clonesLock *sync.Mutex
+	// pendingUnmounts are the mount ids by volume name unmounted while the share was unavailable, whose claims are
+	// released once it is back, guarded by pendingUnmountsLock
+	pendingUnmounts     map[string][]string
+	pendingUnmountsLock *sync.Mutex
+	// remotePath mounted, resolved against the pseudo root
+	remotePath            string
+	healthCheckInterval   time.Duration
+	healthCheckMaxBackoff time.Duration
+	healthCheckTimeout    time.Duration
+	// stopHealthCheck stops the health check, which closes healthCheckDone once it returned
+	stopHealthCheck context.CancelFunc
+	healthCheckDone chan struct{}
+	// stopUsageCheck stops the usage check, which closes usageCheckDone once it returned
+	stopUsageCheck context.CancelFunc
+	usageCheckDone chan struct{}
+	// nodeID recorded with the mounts of this node
+	nodeID   string
+	claimTTL time.Duration
+	// stopHeartbeat stops the renewal of the lease of this node, which closes heartbeatDone once it returned
+	stopHeartbeat context.CancelFunc
+	heartbeatDone chan struct{}
+	// unhealthy is set by the health check from the failed probe until the share is remounted
+	unhealthy atomic.Bool
+	// destroyOnce runs the teardown once, later and concurrent Destroy calls return its destroyErr
+	destroyOnce sync.Once
+	destroyErr  error
+}
 
-	volumeMetadata, err := n.db.GetVolumeMetadata(name)
+// validateVolumeName checks that every path component of name is a plain directory name that is not reserved
+// and that the volume paths fit the filesystem limits
+func (n *nfs) validateVolumeName(name string) error {
+	components := []string{name}
+	if n.opts.AllowNestedNames {
+		components = strings.Split(name, "/")
+	}
+
+	for i, component := range components {
+		switch {
+		case component == "" || component == "." || component == "..":
+			return fmt.Errorf("volume name %s is invalid: empty, . and .. path components are not allowed", name)
+		case strings.Contains(component, "/"):
+			return fmt.Errorf("volume name %s is invalid: / is only allowed when allowNestedNames is enabled", name)
+		case i == 0 && slices.Contains(n.reservedPath, component), len(components) > 1 && (component == "_data" || component == "_mounts" || component == removedMarker):
+			return fmt.Errorf("volume name %s is reserved, please choose a different name", name)
+		case len(component) > n.nameMax:
+			return fmt.Errorf("volume name %s is too long: %d bytes exceeds the %d bytes limit of the backend", name, len(component), n.nameMax)
+		}
+	}
+
+	dataPath := path.Join(n.rootPath, name, "_data")
+	if len(dataPath) >= utils.PathMax {
+		return fmt.Errorf("volume name %s is too long: data path is %d bytes, the limit is %d bytes", name, len(dataPath), utils.PathMax-1)
+	}
 
-	return volumeMetadata.Mountpoint, err
+	return nil
 }
 
-func (n *nfs) Mount(name string, id string) (string, error) {
-	n.lock.Lock()
-	defer n.lock.Unlock()
+// checkNestedName returns an error if the directory of nested volume name would escape rootPath through a symlink,
+// or if name is nested inside another volume or contains one, since purging either would delete the other.
+func (n *nfs) checkNestedName(name string) error {
+	for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+		info, err := os.Lstat(path.Join(n.rootPath, dir))
+		if err == nil && info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("volume name %s is invalid: %s is a symlink", name, dir)
+		}
+	}
 
-	n.logger.Infof("mount volume %s for %s", name, id)
-	return path.Join(name, "_data"), n.db.SetVolumeMetadata(name, func(volumeMetadata *apis.VolumeMetadata) error {
-		if len(volumeMetadata.Status.MountBy) != 0 {
-			return fmt.Errorf("volume %s is already mounted", name)
+	volumeMetadataMap, err := n.db.GetVolumeMetadataMap()
+	if err != nil {
+		return fmt.Errorf("failed to list volumes: %v", err)
+	}
+	for existing := range volumeMetadataMap {
+		if strings.HasPrefix(name, existing+"/") || strings.HasPrefix(existing, name+"/") {
+			return fmt.Errorf("volume name %s conflicts with volume %s, volumes can not be nested inside each other", name, existing)
 		}
+	}
+
+	return nil
+}
 
-		volumeMetadata.Status.MountBy = id
+// checkBackend returns an error while a background mount of the NFS share is still pending,
+// so that volume data and metadata are never written to the local mount point directory.
+func (n *nfs) checkBackend() error {
+	if n.unhealthy.Load() {
+		return fmt.Errorf("NFS backend unavailable: the share on %s is being remounted", n.rootPath)
+	}
+
+	if !n.opts.MountBackground || n.opts.Address == "nfs-server.mock" {
 		return nil
-	})
+	}
+
+	mounted, err := utils.IsMounted(n.rootPath)
+	if err != nil {
+		return fmt.Errorf("failed to check NFS mount root path %s: %v", n.rootPath, err)
+	}
+	if !mounted {
+		return fmt.Errorf("NFS share is not mounted on %s yet, the mount is retrying in background", n.rootPath)
+	}
+
+	return nil
 }
 
-func (n *nfs) Unmount(name string, id string) error {
-	n.lock.Lock()
-	defer n.lock.Unlock()
+func (n *nfs) Create(name string, options map[string]string) (err error) {
+	defer n.lockVolumeTree(name)()
 
-	n.logger.Infof("unmount volume %s from %s", name, id)
+	if n.closed {
+		return apis.ErrShuttingDown
+	}
 
-	return n.db.SetVolumeMetadata(name, func(volumeMetadata *apis.VolumeMetadata) error {
-		if len(volumeMetadata.Status.MountBy) == 0 {
-			return fmt.Errorf("volume %s is not mounted", name)
+	err = n.checkBackend()
+	if err != nil {
+		return err
+	}
+
+	err = n.validateVolumeName(name)
+	if err != nil {
+		return err
+	}
+
+	purgeAfterDelete := n.opts.PurgeAfterDelete
+	warmupOnMount := n.opts.WarmupOnMount
+	readOnly := false
+	subPath := ""
+	var sizeBytes uint64
+	from := ""
+	allowLiveClone := false
+	importPath := ""
+	move := false
+	adoptExisting := false
+	createdAt := time.Now()
+	createdAtSet := false
+	for key, value := range withoutIgnoredOptions(n.logger, name, options, n.opts.IgnoredVolumeOptions) {
+		switch key {
+		case "purgeAfterDelete":
+			purgeAfterDelete, err = strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("invalid value for purgeAfterDelete: %v", err)
+			}
+		case "createdAt":
+			createdAt, err = parseCreatedAt(value)
+			if err != nil {
+				return err
+			}
+			createdAtSet = true
+		case "warmupOnMount":
+			warmupOnMount, err = strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("invalid value for warmupOnMount: %v", err)
+			}
+		case "readOnly", "readonly":
+			readOnly, err = strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("invalid value for readOnly: %v", err)
+			}
+		case "subPath":
+			if !filepath.IsLocal(value) {
+				return fmt.Errorf("invalid value for subPath: %s must be a relative path inside the volume data", value)
+			}
+			subPath = path.Clean(value)
+		case "size":
+			sizeBytes, err = utils.ParseSize(value)
+			if err != nil {
+				return fmt.Errorf("invalid value for size: %v", err)
+			}
+			if sizeBytes == 0 {
+				return fmt.Errorf("invalid value for size: must be greater than 0")
+			}
+		case "from":
+			from = value
+		case "allowLiveClone":
+			allowLiveClone, err = strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("invalid value for allowLiveClone: %v", err)
+			}
+		case "importPath":
+			if !filepath.IsLocal(value) {
+				return fmt.Errorf("invalid value for importPath: %s must be a relative path on the share", value)
+			}
+			importPath = path.Clean(value)
+		case "move":
+			move, err = strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("invalid value for move: %v", err)
+			}
+		case "adoptExisting":
+			adoptExisting, err = strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("invalid value for adoptExisting: %v", err)
+			}
+		default:
+			return fmt.Errorf("unknown option %s with value %s, ignoring", key, value)
 		}
+	}
+
+	n.logger.Infof("create volume %s", name)
 
-		if volumeMetadata.Status.MountBy != id {
-			return fmt.Errorf("volume %s already mounted by %s", name, volumeMetadata.Status.MountBy)
+	if n.opts.AllowNestedNames {
+		err = n.checkNestedName(name)
+		if err != nil {
+			return err
 		}
+	}
 
-		volumeMetadata.Status.MountBy = ""
-		return nil
-	})
-}
+	err = checkDataPath(path.Join(n.rootPath, name, "_data"), true)
+	if err != nil {
+		return err
+	}
 
-func (n *nfs) Destroy() error {
-	err := n.db.Close()
+	// Data kept by a removal without purge is reused as before, while data left without metadata by a crash is only
+	// reused on request
+	removed := n.isRemoved(name)
+	orphan, err := n.isOrphan(name)
 	if err != nil {
-		n.logger.Warningf("failed to close badger db: %v", err)
+		return err
+	}
+	if orphan && !adoptExisting {
+		return fmt.Errorf("volume directory %s already exists without metadata, create with adoptExisting to adopt its data", name)
+	}
+	if orphan && !createdAtSet {
+		createdAt, err = n.orphanCreatedAt(name)
+		if err != nil {
+			return err
+		}
 	}
 
-	if n.opts.Address != "nfs-server.mock" {
-		err = utils.Umount(n.rootPath)
+	change := createDirectoryChange(n.rootPath, name)
+	var cloneStatus *apis.CloneStatus
+	switch {
+	case from != "" && importPath != "":
+		return fmt.Errorf("from and importPath can not be combined")
+	case adoptExisting && (from != "" || importPath != ""):
+		return fmt.Errorf("adoptExisting can not be combined with from or importPath")
+	case from != "":
+		err = n.checkCloneSource(name, from, allowLiveClone)
+		if err != nil {
+			return err
+		}
+		cloneStatus = &apis.CloneStatus{Source: from, State: cloneRunning, UpdatedAt: time.Now(), Node: n.nodeID}
+	case importPath != "":
+		err = n.checkImportPath(name, importPath)
 		if err != nil {
-			return fmt.Errorf("failed to unmount NFS mount root path %s: %v", n.rootPath, err)
+			return err
 		}
+		change = importDirectoryChange(n.rootPath, name, importPath, move)
 	}
 
-	return nil
+	backendData, err := json.Marshal(&nfsBackendData{Export: n.export()})
+	if err != nil {
+		return fmt.Errorf("failed to marshal backend data: %v", err)
+	}
+
+	var created *apis.VolumeMetadata
+	err = backendError(runTwoPhase(change, func() error {
+		return n.db.CreateVolumeMetadata(name, func(volumeMetadata *apis.VolumeMetadata) error {
+			*volumeMetadata = apis.VolumeMetadata{
+				Mountpoint: path.Join(name, "_data", subPath),
+				CreatedAt:  createdAt,
+				Spec: &apis.VolumeSpec{
+					PurgeAfterDelete: purgeAfterDelete,
+					WarmupOnMount:    warmupOnMount,
+					ReadOnly:         readOnly,
+					SubPath:          subPath,
+					SizeBytes:        sizeBytes,
+					ImportPath:       importPath,
+				},
+				Status:      &apis.VolumeStatus{Clone: cloneStatus},
+				BackendData: backendData,
+			}
+			created = cloneVolumeMetadata(volumeMetadata)
+			return nil
+		})
+	}))
+	if err != nil {
+		return err
+	}
+
+	if removed {
+		err = os.Remove(path.Join(n.rootPath, name, removedMarker))
+		if err != nil && !os.IsNotExist(err) {
+			n.logger.Warningf("failed to clear removed mark of volume %s: %v", name, err)
+		}
+	}
+
+	n.recordHistory(name, historyCreate, "", nil, created)
+
+	if from != "" {
+		n.startClone(name, from)
+	}
+
+	return nil
+}
+
+func (n *nfs) List() (map[string]*apis.VolumeMetadata, error) {
+	volumeMetadataMap, err := n.list()
+	if err != nil {
+		return volumeMetadataMap, err
+	}
+
+	for name, volumeMetadata := range volumeMetadataMap {
+		n.withUsage(context.Background(), name, volumeMetadata)
+	}
+
+	return volumeMetadataMap, nil
+}
+
+// list returns the metadata of all volumes with the progress of their warmups
+func (n *nfs) list() (map[string]*apis.VolumeMetadata, error) {
+	n.lock.RLock()
+	defer n.lock.RUnlock()
+
+	err := n.checkBackend()
+	if err != nil {
+		return nil, err
+	}
+
+	n.logger.Info("list volumes")
+
+	volumeMetadataMap, err := n.db.GetVolumeMetadataMap()
+	if err != nil {
+		return volumeMetadataMap, err
+	}
+
+	for name, volumeMetadata := range volumeMetadataMap {
+		// Entries that could never be created as volumes are internal or stray keys and must not be listed
+		if err := n.validateVolumeName(name); err != nil {
+			n.logger.Warningf("skip invalid metadata entry %s: %v", name, err)
+			delete(volumeMetadataMap, name)
+			continue
+		}
+
+		n.withWarmupProgress(name, volumeMetadata)
+	}
+
+	n.reconcileOrphans(volumeMetadataMap)
+
+	return volumeMetadataMap, nil
+}
+
+func (n *nfs) Get(name string) (*apis.VolumeMetadata, error) {
+	volumeMetadata, err := n.get(name)
+	if err != nil {
+		return volumeMetadata, err
+	}
+
+	n.withUsage(context.Background(), name, volumeMetadata)
+
+	return volumeMetadata, nil
+}
+
+// get returns the metadata of volume name with the progress of its warmup
+func (n *nfs) get(name string) (*apis.VolumeMetadata, error) {
+	defer n.lockVolume(name, true)()
+
+	err := n.checkBackend()
+	if err != nil {
+		return nil, err
+	}
+
+	n.logger.Infof("get volume %s", name)
+
+	volumeMetadata, err := n.db.GetVolumeMetadata(name)
+	if err != nil {
+		return volumeMetadata, err
+	}
+
+	n.withWarmupProgress(name, volumeMetadata)
+
+	return volumeMetadata, nil
+}
+
+func (n *nfs) Remove(name string) error {
+	return n.remove(name, false)
+}
+
+// ForceRemove removes volume name even when other nodes still claim mounts of it
+func (n *nfs) ForceRemove(name string) error {
+	return n.remove(name, true)
+}
+
+// remove removes volume name once it is no longer mounted, the mounts of nodes whose lease expired are dropped first
+// and with force all mounts are
+func (n *nfs) remove(name string, force bool) error {
+	defer n.lockVolumeTree(name)()
+
+	err := n.checkBackend()
+	if err != nil {
+		return err
+	}
+
+	n.logger.Infof("remove volume %s", name)
+
+	n.releasePendingUnmounts(name)
+
+	purge := false
+	var removed *apis.VolumeMetadata
+	purgeChange := fsChange{
+		Commit: func() error {
+			if !purge {
+				n.markRemoved(name)
+				return nil
+			}
+
+			err := utils.RemoveAllWithRetry(path.Join(n.rootPath, name), n.opts.PurgeRetries, n.purgeRetryInterval)
+			if err != nil {
+				return fmt.Errorf("failed to remove volume data: %v", err)
+			}
+
+			// Clean up the parent directories of a nested volume once they are empty
+			for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+				if os.Remove(path.Join(n.rootPath, dir)) != nil {
+					break
+				}
+			}
+			return nil
+		},
+	}
+
+	// The copy must stop writing before the data can be purged
+	n.stopClone(name)
+
+	err = runTwoPhase(purgeChange, func() error {
+		return n.db.DeleteVolumeMetadata(name, func(volumeMetadata *apis.VolumeMetadata) error {
+			n.dropStaleClaims(name, volumeMetadata, force)
+			if len(volumeMetadata.Status.MountBy) != 0 {
+				return fmt.Errorf("volume %s is mounted by %s, unmount it before removing", name, describeMounts(volumeMetadata))
+			}
+
+			err := n.releaseLeftoverMounts(name)
+			if err != nil {
+				return err
+			}
+
+			// The data of an incomplete clone is never kept
+			clone := volumeMetadata.Status.Clone
+			purge = volumeMetadata.Spec.PurgeAfterDelete || clone != nil && clone.State != cloneCompleted
+			removed = cloneVolumeMetadata(volumeMetadata)
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	n.recordHistory(name, historyRemove, "", removed, nil)
+
+	return nil
+}
+
+func (n *nfs) Path(name string) (string, error) {
+	defer n.lockVolume(name, true)()
+
+	err := n.checkBackend()
+	if err != nil {
+		return "", err
+	}
+
+	n.logger.Infof("path volume %s", name)
+
+	err = n.validateVolumeName(name)
+	if err != nil {
+		return "", err
+	}
+
+	volumeMetadata, err := n.db.GetVolumeMetadata(name)
+	if err != nil {
+		return "", err
+	}
+
+	// The metadata stores the mountpoint relative to the root, so the path follows the root across restarts
+	return path.Join(n.rootPath, volumeMetadata.Mountpoint), nil
+}
+
+func (n *nfs) Mount(name string, id string) (string, error) {
+	defer n.lockVolume(name, false)()
+
+	err := n.checkBackend()
+	if err != nil {
+		return "", err
+	}
+
+	n.logger.Infof("mount volume %s for %s", name, id)
+
+	err = n.validateVolumeName(name)
+	if err != nil {
+		return "", err
+	}
+
+	// A container mounting again with the id it unmounted while the share was unavailable must find it released
+	n.releasePendingUnmounts(name)
+
+	if id == "" || id == "." || id == ".." || strings.Contains(id, "/") {
+		return "", fmt.Errorf("mount id %s is invalid: empty, ., .. and / are not allowed", id)
+	}
+
+	if n.webhook != nil {
+		err = n.webhook.admit(name, id)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	warmupOnMount := false
+	mountpoint := ""
+	var before, after *apis.VolumeMetadata
+	err = n.db.SetVolumeMetadata(name, func(volumeMetadata *apis.VolumeMetadata) error {
+		if slices.Contains(volumeMetadata.Status.MountBy, id) {
+			if !n.claimStale(volumeMetadata, id) {
+				return fmt.Errorf("volume %s is already mounted by %s", name, describeMount(volumeMetadata, id))
+			}
+			n.logger.Warningf("take over mount %s of volume %s, the lease of its node expired", describeMount(volumeMetadata, id), name)
+			releaseClaim(volumeMetadata, id)
+		}
+		if clone := volumeMetadata.Status.Clone; clone != nil && clone.State != cloneCompleted {
+			return fmt.Errorf("volume %s can not be mounted, its clone from %s is %s", name, clone.Source, clone.State)
+		}
+		before = cloneVolumeMetadata(volumeMetadata)
+
+		err := checkDataPath(path.Join(n.rootPath, name, "_data"), false)
+		if err != nil {
+			return err
+		}
+
+		n.checkExport(name, volumeMetadata)
+
+		boundPath, err := n.bindMountPath(name, id, volumeMetadata)
+		if err != nil {
+			return err
+		}
+		mountpoint = boundPath
+
+		// Only the first mount warms up the shared data
+		if len(volumeMetadata.Status.MountBy) == 0 && volumeMetadata.Spec.WarmupOnMount {
+			warmupOnMount = true
+			volumeMetadata.Status.Warmup = &apis.WarmupStatus{State: warmupRunning, UpdatedAt: time.Now()}
+		}
+		n.claimMount(volumeMetadata, id)
+		after = cloneVolumeMetadata(volumeMetadata)
+		return nil
+	})
+	if err != nil {
+		// No path is returned with an error, so docker never binds a directory that was not prepared
+		return "", backendError(err)
+	}
+
+	n.recordHistory(name, historyMount, id, before, after)
+
+	if warmupOnMount {
+		n.startWarmup(name)
+	}
+
+	return path.Join(n.rootPath, mountpoint), nil
+}
+
+func (n *nfs) Unmount(name string, id string) error {
+	defer n.lockVolume(name, false)()
+
+	err := n.checkBackend()
+	if err != nil {
+		return n.unmountUnavailable(name, id, err)
+	}
+
+	n.logger.Infof("unmount volume %s from %s", name, id)
+
+	return n.releaseMount(name, id)
+}
+
+// releaseMount releases the mount of volume name for id, holding the lock of volume name with the share available
+func (n *nfs) releaseMount(name string, id string) error {
+	unmounted := false
+	var before, after *apis.VolumeMetadata
+	err := n.db.SetVolumeMetadata(name, func(volumeMetadata *apis.VolumeMetadata) error {
+		if len(volumeMetadata.Status.MountBy) == 0 {
+			return fmt.Errorf("volume %s is not mounted", name)
+		}
+
+		if !slices.Contains(volumeMetadata.Status.MountBy, id) {
+			return fmt.Errorf("volume %s is not mounted by %s, mounted by %s", name, id, describeMounts(volumeMetadata))
+		}
+		if node := volumeMetadata.Status.MountNodes[id]; node != "" && node != n.nodeID {
+			return fmt.Errorf("volume %s is mounted by %s, which only that node can unmount", name, describeMount(volumeMetadata, id))
+		}
+
+		err := n.releaseMountPath(mountPath(name, id))
+		if err != nil {
+			return fmt.Errorf("failed to release mount of volume %s for %s: %v", name, id, err)
+		}
+
+		before = cloneVolumeMetadata(volumeMetadata)
+		releaseClaim(volumeMetadata, id)
+		unmounted = len(volumeMetadata.Status.MountBy) == 0
+		after = cloneVolumeMetadata(volumeMetadata)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	n.recordHistory(name, historyUnmount, id, before, after)
+
+	// The warmup serves all holders, it stops once the last of them is gone
+	n.warmupsLock.Lock()
+	defer n.warmupsLock.Unlock()
+
+	if warmup := n.warmups[name]; unmounted && warmup != nil {
+		warmup.cancel()
+	}
+
+	return nil
+}
+
+// checkCloneSource checks that the data of volume source can be copied into volume name
+func (n *nfs) checkCloneSource(name string, source string, allowLiveClone bool) error {
+	if source == name {
+		return fmt.Errorf("volume %s can not be cloned from itself", name)
+	}
+
+	err := n.validateVolumeName(source)
+	if err != nil {
+		return err
+	}
+
+	sourceMetadata, err := n.db.GetVolumeMetadata(source)
+	if err != nil {
+		return fmt.Errorf("failed to get volume %s to clone: %w", source, err)
+	}
+	if clone := sourceMetadata.Status.Clone; clone != nil && clone.State != cloneCompleted {
+		return fmt.Errorf("volume %s to clone is not complete, its own clone from %s is %s", source, clone.Source, clone.State)
+	}
+	if len(sourceMetadata.Status.MountBy) != 0 && !allowLiveClone {
+		return fmt.Errorf("volume %s to clone is mounted by %s, set allowLiveClone to clone it while in use", source, describeMounts(sourceMetadata))
+	}
+
+	return nil
+}
+
+// checkImportPath checks that importPath is a directory on the share that is neither reserved nor related to a volume,
+// so that adopting it as the data of volume name takes nothing away from another volume
+func (n *nfs) checkImportPath(name string, importPath string) error {
+	if slices.Contains(n.reservedPath, strings.Split(importPath, "/")[0]) {
+		return fmt.Errorf("importPath %s is reserved", importPath)
+	}
+
+	volumeMetadataMap, err := n.db.GetVolumeMetadataMap()
+	if err != nil {
+		return err
+	}
+	for _, volume := range append(slices.Collect(maps.Keys(volumeMetadataMap)), name) {
+		if volume == importPath || strings.HasPrefix(importPath, volume+"/") || strings.HasPrefix(volume, importPath+"/") {
+			return fmt.Errorf("importPath %s overlaps volume %s", importPath, volume)
+		}
+	}
+
+	_, err = os.Lstat(path.Join(n.rootPath, name, "_data"))
+	if !os.IsNotExist(err) {
+		return fmt.Errorf("volume %s already has a data directory, importPath can only be used for new volumes", name)
+	}
+
+	// Symlinks on the share must not lead the import out of it
+	importDir := path.Join(n.rootPath, importPath)
+	info, err := os.Lstat(importDir)
+	if err != nil {
+		return fmt.Errorf("failed to stat importPath %s: %v", importPath, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("importPath %s is not a directory", importPath)
+	}
+	resolvedPath, err := filepath.EvalSymlinks(importDir)
+	if err != nil {
+		return fmt.Errorf("failed to resolve importPath %s: %v", importPath, err)
+	}
+	resolvedRootPath, err := filepath.EvalSymlinks(n.rootPath)
+	if err != nil {
+		return fmt.Errorf("failed to resolve root path: %v", err)
+	}
+	if relPath, err := filepath.Rel(resolvedRootPath, resolvedPath); err != nil || !filepath.IsLocal(relPath) {
+		return fmt.Errorf("importPath %s resolves outside of the share", importPath)
+	}
+
+	return nil
+}
+
+// mountPath returns the path of the bind mount of volume name for mount id, relative to the driver root
+func mountPath(name string, id string) string {
+	return path.Join(name, "_mounts", id)
+}
+
+// bindMountPath binds the data of volume name, or its sub path, on the mount path of mount id, read-only for
+// read-only volumes, and returns the mount path
+func (n *nfs) bindMountPath(name string, id string, volumeMetadata *apis.VolumeMetadata) (string, error) {
+	dataPath := path.Join(n.rootPath, name, "_data")
+	sourcePath := path.Join(dataPath, volumeMetadata.Spec.SubPath)
+
+	err := os.MkdirAll(sourcePath, 0755)
+	if err != nil {
+		return "", fmt.Errorf("failed to create sub path %s of volume %s: %v", volumeMetadata.Spec.SubPath, name, err)
+	}
+
+	// Containers can plant symlinks in the data, the sub path must not lead out of it
+	resolvedPath, err := filepath.EvalSymlinks(sourcePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve sub path %s of volume %s: %v", volumeMetadata.Spec.SubPath, name, err)
+	}
+	resolvedDataPath, err := filepath.EvalSymlinks(dataPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve data path of volume %s: %v", name, err)
+	}
+	if relPath, err := filepath.Rel(resolvedDataPath, resolvedPath); err != nil || !filepath.IsLocal(relPath) {
+		return "", fmt.Errorf("sub path %s of volume %s resolves outside of the volume data", volumeMetadata.Spec.SubPath, name)
+	}
+
+	// A bind mount left behind by a crash is replaced, the volume spec may have changed since
+	mountpoint := mountPath(name, id)
+	err = n.releaseMountPath(mountpoint)
+	if err != nil {
+		return "", fmt.Errorf("failed to release leftover mount of volume %s for %s: %v", name, id, err)
+	}
+
+	err = os.MkdirAll(path.Join(n.rootPath, mountpoint), 0755)
+	if err != nil {
+		return "", fmt.Errorf("failed to create mount point of volume %s for %s: %v", name, id, err)
+	}
+	if n.opts.Address == "nfs-server.mock" {
+		return mountpoint, nil
+	}
+
+	mountOptions := []string{"rw"}
+	if volumeMetadata.Spec.ReadOnly {
+		mountOptions = []string{"ro"}
+	}
+	err = utils.Bind(resolvedPath, path.Join(n.rootPath, mountpoint), mountOptions)
+	if err != nil {
+		return "", fmt.Errorf("failed to bind volume %s for %s: %v", name, id, err)
+	}
+	return mountpoint, nil
+}
+
+// releaseMountPath unmounts the bind mount at mountpoint, relative to the driver root, and removes its directory.
+// The directory is only removed when empty, so data is never deleted through a bind mount that is still there.
+func (n *nfs) releaseMountPath(mountpoint string) error {
+	mountpointPath := path.Join(n.rootPath, mountpoint)
+	_, err := os.Stat(mountpointPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if n.opts.Address != "nfs-server.mock" {
+		mounted, err := utils.IsMounted(mountpointPath)
+		if err != nil {
+			return fmt.Errorf("failed to check bind mount %s: %v", mountpoint, err)
+		}
+		if mounted {
+			err = utils.Umount(mountpointPath)
+			if err != nil {
+				return fmt.Errorf("failed to unmount bind mount %s: %v", mountpoint, err)
+			}
+		}
+	}
+
+	err = os.Remove(mountpointPath)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove mount point %s: %v", mountpoint, err)
+	}
+	return nil
+}
+
+// releaseLeftoverMounts releases the bind mounts of volume name whose holders went away without unmounting,
+// as left behind by a crash
+func (n *nfs) releaseLeftoverMounts(name string) error {
+	mountsPath := path.Join(n.rootPath, name, "_mounts")
+	entries, err := os.ReadDir(mountsPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read mounts of volume %s: %v", name, err)
+	}
+
+	for _, entry := range entries {
+		n.logger.Warningf("release leftover mount of volume %s for %s", name, entry.Name())
+		err = n.releaseMountPath(mountPath(name, entry.Name()))
+		if err != nil {
+			return fmt.Errorf("failed to release leftover mount of volume %s for %s: %v", name, entry.Name(), err)
+		}
+	}
+
+	err = os.Remove(mountsPath)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove mounts of volume %s: %v", name, err)
+	}
+	return nil
+}
+
+// export returns the NFS export backing the driver
+func (n *nfs) export() string {
+	return fmt.Sprintf("%s:%s", n.opts.Address, n.opts.RemotePath)
+}
+
+// checkExport warns when volume name was created on another export than the one backing the driver,
+// which happens when the share content was moved or the metadata was copied from another export
+func (n *nfs) checkExport(name string, volumeMetadata *apis.VolumeMetadata) {
+	if len(volumeMetadata.BackendData) == 0 {
+		return
+	}
+
+	backendData := &nfsBackendData{}
+	err := json.Unmarshal(volumeMetadata.BackendData, backendData)
+	if err != nil {
+		n.logger.Warningf("failed to parse backend data of volume %s: %v", name, err)
+		return
+	}
+
+	if backendData.Export != n.export() {
+		n.logger.Warningf("volume %s was created on export %s but is served from %s", name, backendData.Export, n.export())
+	}
+}
+
+// startWarmup prefetches the data of volume name in background, must be called with the volume locked.
+// A previous warmup of the volume is canceled, and only the latest warmup records its result.
+func (n *nfs) startWarmup(name string) {
+	n.warmupsLock.Lock()
+	defer n.warmupsLock.Unlock()
+
+	if previous := n.warmups[name]; previous != nil {
+		previous.cancel()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), n.warmupTimeout)
+	current := &warmup{cancel: cancel}
+	n.warmups[name] = current
+
+	go func() {
+		defer cancel()
+
+		state, err := prefetch(ctx, path.Join(n.rootPath, name, "_data"), n.opts.WarmupMaxBytes, &current.bytes)
+		if err != nil {
+			n.logger.Warningf("failed to warmup volume %s: %v", name, err)
+		}
+		n.logger.Infof("warmup of volume %s %s after reading %d bytes", name, state, current.bytes.Load())
+
+		defer n.lockVolume(name, false)()
+
+		n.warmupsLock.Lock()
+		latest := n.warmups[name] == current
+		if latest {
+			delete(n.warmups, name)
+		}
+		n.warmupsLock.Unlock()
+
+		if !latest {
+			return
+		}
+
+		if n.closed {
+			return
+		}
+
+		var before, after *apis.VolumeMetadata
+		err = n.db.SetVolumeMetadata(name, func(volumeMetadata *apis.VolumeMetadata) error {
+			before = cloneVolumeMetadata(volumeMetadata)
+			volumeMetadata.Status.Warmup = &apis.WarmupStatus{State: state, Bytes: current.bytes.Load(), UpdatedAt: time.Now()}
+			after = cloneVolumeMetadata(volumeMetadata)
+			return nil
+		})
+		if err != nil {
+			n.logger.Warningf("failed to record warmup status of volume %s: %v", name, err)
+			return
+		}
+
+		n.recordHistory(name, historyWarmup, "", before, after)
+	}()
+}
+
+// withWarmupProgress fills in the progress of a running warmup of volume name
+func (n *nfs) withWarmupProgress(name string, volumeMetadata *apis.VolumeMetadata) {
+	n.warmupsLock.Lock()
+	warmup := n.warmups[name]
+	n.warmupsLock.Unlock()
+
+	if warmup == nil || volumeMetadata.Status.Warmup == nil || volumeMetadata.Status.Warmup.State != warmupRunning {
+		return
+	}
+
+	volumeMetadata.Status.Warmup.Bytes = warmup.bytes.Load()
+}
+
+// refreshServerIdentity records which server actually backs the NFS mount,
+// which may differ from the configured address when it is a DNS name or a VIP in front of a cluster.
+func (n *nfs) refreshServerIdentity() {
+	identity := map[string]interface{}{
+		"address": n.opts.Address,
+	}
+
+	if n.opts.Address != "nfs-server.mock" {
+		serverAddress, err := utils.NFSServerAddress(n.rootPath)
+		if err != nil {
+			n.logger.Debugf("failed to get server address from mount info, fall back to DNS: %v", err)
+			addresses, lookupErr := net.LookupHost(n.opts.Address)
+			if lookupErr == nil && len(addresses) != 0 {
+				serverAddress = addresses[0]
+			}
+		}
+		if serverAddress != "" {
+			identity["serverAddress"] = serverAddress
+		}
+	}
+
+	fsid, err := utils.FilesystemID(n.rootPath)
+	if err != nil {
+		n.logger.Warningf("failed to get filesystem id of %s: %v", n.rootPath, err)
+	} else {
+		identity["fsid"] = fsid
+	}
+
+	n.logger.Infof("NFS share %s is served by %v", n.opts.Address, identity)
+	n.serverIdentity.Store(&identity)
+}
+
+func (n *nfs) History(name string) ([]*apis.VolumeHistoryEntry, error) {
+	defer n.lockVolume(name, true)()
+
+	err := n.checkBackend()
+	if err != nil {
+		return nil, err
+	}
+
+	n.logger.Infof("history of volume %s", name)
+
+	return n.db.GetVolumeHistory(name)
+}
+
+func (n *nfs) Status() map[string]interface{} {
+	status := maps.Clone(*n.serverIdentity.Load())
+	status["nodeID"] = n.nodeID
+	return status
+}
+
+func (n *nfs) Destroy() error {
+	n.destroyOnce.Do(func() {
+		n.destroyErr = n.destroy()
+	})
+	return n.destroyErr
+}
+
+func (n *nfs) destroy() error {
+	// An unhealthy share is detached before waiting for anything, as operations hanging on it may never return and a
+	// regular unmount would hang as well, so that no dead mount blocks the next start
+	detached := n.opts.Address != "nfs-server.mock" && n.checkBackend() != nil
+	var detachErr error
+	if detached {
+		n.logger.Warningf("NFS share on %s is unavailable, detach it lazily", n.rootPath)
+		detachErr = n.unmountShare(detachMount)
+	}
+
+	if n.stopHeartbeat != nil {
+		n.stopHeartbeat()
+		n.waitStopped("heartbeat", n.heartbeatDone)
+	}
+	// The health and usage checks take the lock, so they are stopped before taking it
+	if n.stopHealthCheck != nil {
+		n.stopHealthCheck()
+		n.waitStopped("health check", n.healthCheckDone)
+	}
+	if n.stopUsageCheck != nil {
+		n.stopUsageCheck()
+		n.waitStopped("usage check", n.usageCheckDone)
+	}
+
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	n.closed = true
+	n.warmupsLock.Lock()
+	for _, warmup := range n.warmups {
+		warmup.cancel()
+	}
+	n.warmupsLock.Unlock()
+
+	// Clones close done before taking any lock, so waiting for them under lock is safe. Their result is recorded here,
+	// as they find the driver closed once they get the lock.
+	n.clonesLock.Lock()
+	for name, clone := range n.clones {
+		clone.cancel()
+		<-clone.done
+
+		status := &apis.CloneStatus{Source: clone.source, State: cloneCompleted, UpdatedAt: time.Now(), Node: n.nodeID}
+		if clone.err != nil {
+			n.logger.Warningf("clone of volume %s from %s is interrupted by shutdown", name, clone.source)
+			status.State = cloneFailed
+			status.Error = cloneShutdownError
+		}
+		n.setCloneStatus(name, status)
+	}
+	n.clonesLock.Unlock()
+
+	err := n.db.Close()
+	if err != nil {
+		n.logger.Warningf("failed to close metadata store: %v", err)
+	}
+
+	if detached {
+		return detachErr
+	}
+	return n.unmountShare(utils.Umount)
+}
+
+// unmountShare unmounts the mounts backing the driver with umount
+func (n *nfs) unmountShare(umount func(mountpoint string) error) error {
+	if n.opts.Address == "nfs-server.mock" {
+		return nil
+	}
+
+	if n.metadataPath != n.rootPath {
+		err := umount(n.metadataPath)
+		if err != nil {
+			n.logger.Warningf("failed to unmount NFS metadata mount path %s: %v", n.metadataPath, err)
+		}
+	}
+
+	err := umount(n.rootPath)
+	if err != nil {
+		return fmt.Errorf("failed to unmount NFS mount root path %s: %v", n.rootPath, err)
+	}
+	return nil
+}
+
+// waitStopped waits for the background task name to close done for at most healthCheckTimeout, as a task hanging on
+// a dead mount may never return
+func (n *nfs) waitStopped(name string, done chan struct{}) {
+	select {
+	case <-done:
+	case <-time.After(n.healthCheckTimeout):
+		n.logger.Warningf("%s did not stop within %s, continue shutdown without it", name, n.healthCheckTimeout)
+	}
 }
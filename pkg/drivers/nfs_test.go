@@ -2,10 +2,26 @@ package drivers
 
 import (
 	"context"
+	"docker-volume-plugin/pkg/drivers/apis"
 	"docker-volume-plugin/pkg/log"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
 	"testing"
+	"time"
+
+	badgerdb "github.com/dgraph-io/badger/v4"
 )
 
 var localNFSServerDriverOptions string = `{
@@ -13,6 +29,22 @@ var localNFSServerDriverOptions string = `{
 	"remotePath": "/mock"
 }`
 
+// newTestNFSDriver creates a mock nfs driver under a temporary mountpoint that is destroyed and removed after the test
+func newTestNFSDriver(t *testing.T, driverOptions string) (apis.Driver, string) {
+	propagatedMountpoint := t.TempDir()
+	driver, err := New(context.Background(), log.New("test-nfs").WithLogLevel(log.WarnLevel), "nfs", propagatedMountpoint, driverOptions)
+	if err != nil {
+		t.Fatalf("got error when new nfs driver: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := driver.Destroy(); err != nil {
+			t.Errorf("got error when destroy nfs driver: %v", err)
+		}
+	})
+
+	return driver, propagatedMountpoint
+}
+
 func TestNFSDriver(t *testing.T) {
 	propagatedMountpoint := path.Join(os.TempDir(), "net-volume-nfs-test")
 	driver, err := New(context.Background(), log.New("test-nfs"), "nfs", propagatedMountpoint, localNFSServerDriverOptions)
@@ -49,13 +81,22 @@ func TestNFSDriver(t *testing.T) {
 		t.Fatalf("got error when get volume tes for nfs driver: %v", err)
 	}
 
+	// Test Status
+	status := driver.Status()
+	if status["address"] != "nfs-server.mock" {
+		t.Errorf("expected address nfs-server.mock in status, got %v", status)
+	}
+	if _, ok := status["fsid"]; !ok {
+		t.Errorf("expected fsid in status, got %v", status)
+	}
+
 	// Test Path
 	mountpoint, err := driver.Path("test")
 	if err != nil {
 		t.Fatalf("got error when get volume test for nfs driver: %v", err)
 	}
-	if mountpoint != path.Join("test", "_data") {
-		t.Errorf("expected mountpoint %s, got %s for nfs driver", path.Join("test", "_data"), mountpoint)
+	if mountpoint != path.Join(propagatedMountpoint, "test", "_data") {
+		t.Errorf("expected mountpoint %s, got %s for nfs driver", path.Join(propagatedMountpoint, "test", "_data"), mountpoint)
 	}
 	_, err = driver.Path("non-exist")
 	if err == nil {
@@ -72,8 +113,8 @@ func TestNFSDriver(t *testing.T) {
 		t.Fatalf("expect got error when mount mounted volume test")
 	}
 	_, err = driver.Mount("test", "2")
-	if err == nil {
-		t.Fatalf("expect got error when mount mounted volume test")
+	if err != nil {
+		t.Fatalf("got error when mount volume test for another container: %v", err)
 	}
 	_, err = driver.Mount("non-exist", "1")
 	if err == nil {
@@ -82,20 +123,41 @@ func TestNFSDriver(t *testing.T) {
 
 	// Test Remove mounted volume
 	err = driver.Remove("test")
-	if err == nil {
-		t.Fatalf("expect got error when remove mounted volume test")
+	if err == nil || !strings.Contains(err.Error(), "mounted by 1, 2") {
+		t.Fatalf("expect got error listing holders when remove mounted volume test, got %v", err)
 	}
 
 	// Test Unmount
-	_, err = driver.Mount("test", "2")
+	err = driver.Unmount("test", "3")
 	if err == nil {
-		t.Fatalf("expect got error when unmount volume mount by other id")
+		t.Fatalf("expect got error when unmount volume test not mounted by id 3")
 	}
 	err = driver.Unmount("test", "1")
 	if err != nil {
 		t.Fatalf("got error when umount volume test: %v", err)
 	}
 	err = driver.Unmount("test", "1")
+	if err == nil {
+		t.Fatalf("expect got error when umount volume test twice by id 1")
+	}
+
+	// Test volume still in use by the other container
+	volumeMetadata, err := driver.Get("test")
+	if err != nil {
+		t.Fatalf("got error when get volume test: %v", err)
+	}
+	if !slices.Equal(volumeMetadata.Status.MountBy, apis.MountIDs{"2"}) {
+		t.Errorf("expected volume test mounted by 2, got %v", volumeMetadata.Status.MountBy)
+	}
+	err = driver.Remove("test")
+	if err == nil {
+		t.Fatalf("expect got error when remove volume test still mounted by 2")
+	}
+	err = driver.Unmount("test", "2")
+	if err != nil {
+		t.Fatalf("got error when umount volume test: %v", err)
+	}
+	err = driver.Unmount("test", "2")
 	if err == nil {
 		t.Fatalf("expect got error when umount unmounted volume test")
 	}
@@ -119,3 +181,2387 @@ func TestNFSDriver(t *testing.T) {
 		t.Errorf("expected 0 volumes, got %d volume for nfs driver", len(volumeMetadataMap))
 	}
 }
+
+func TestNFSDriverInvalidNames(t *testing.T) {
+	driver, _ := newTestNFSDriver(t, localNFSServerDriverOptions)
+
+	err := driver.Create("metadata.db", nil)
+	if err == nil {
+		t.Errorf("expect got error when create volume with reserved name")
+	}
+	err = driver.Create("../escape", nil)
+	if err == nil {
+		t.Errorf("expect got error when create volume with traversal name")
+	}
+	err = driver.Create(strings.Repeat("a", 300), nil)
+	if err == nil || !strings.Contains(err.Error(), "too long") {
+		t.Errorf("expect got too long error when create volume with long name, got %v", err)
+	}
+}
+
+func TestNFSDriverCreateRacingDestroy(t *testing.T) {
+	propagatedMountpoint := path.Join(os.TempDir(), "net-volume-nfs-race-test")
+	driver, err := New(context.Background(), log.New("test-nfs").WithLogLevel(log.WarnLevel), "nfs", propagatedMountpoint, localNFSServerDriverOptions)
+	if err != nil {
+		t.Fatalf("got error when new nfs driver: %v", err)
+	}
+	defer func() {
+		if err := os.RemoveAll(propagatedMountpoint); err != nil {
+			t.Errorf("got error when remove propagated mountpoint %s: %v", propagatedMountpoint, err)
+		}
+	}()
+
+	const count = 8
+	errs := make([]error, count)
+	wg := &sync.WaitGroup{}
+	for i := 0; i < count; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = driver.Create(fmt.Sprintf("race-%d", i), nil)
+		}(i)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := driver.Destroy(); err != nil {
+			t.Errorf("got error when destroy nfs driver: %v", err)
+		}
+	}()
+	wg.Wait()
+
+	for i, err := range errs {
+		dataPath := path.Join(propagatedMountpoint, fmt.Sprintf("race-%d", i), "_data")
+		_, statErr := os.Stat(dataPath)
+		if err == nil {
+			if statErr != nil {
+				t.Errorf("expected %s to exist for created volume, got %v", dataPath, statErr)
+			}
+			continue
+		}
+		if !errors.Is(err, apis.ErrShuttingDown) {
+			t.Errorf("expected shutting down error for race-%d, got %v", i, err)
+		}
+		if !os.IsNotExist(statErr) {
+			t.Errorf("expected no orphaned directory %s, got %v", dataPath, statErr)
+		}
+	}
+
+	err = driver.Create("after-destroy", nil)
+	if !errors.Is(err, apis.ErrShuttingDown) {
+		t.Errorf("expected shutting down error after destroy, got %v", err)
+	}
+}
+
+func TestResolveRemotePath(t *testing.T) {
+	cases := []struct {
+		remotePath   string
+		pseudoRoot   string
+		mountOptions []string
+		expected     string
+		expectError  bool
+	}{
+		{remotePath: "/srv/nfs/data", pseudoRoot: "", mountOptions: []string{"nfsvers=4"}, expected: "/srv/nfs/data"},
+		{remotePath: "/srv/nfs/data", pseudoRoot: "/srv/nfs", mountOptions: []string{"nfsvers=4"}, expected: "/data"},
+		{remotePath: "/srv/nfs/data", pseudoRoot: "/srv/nfs/", mountOptions: []string{"vers=4.1"}, expected: "/data"},
+		{remotePath: "/srv/nfs", pseudoRoot: "/srv/nfs", mountOptions: nil, expected: "/"},
+		{remotePath: "/srv/nfs/data", pseudoRoot: "/srv/nfs", mountOptions: []string{"nfsvers=3"}, expected: "/srv/nfs/data"},
+		{remotePath: "/srv/nfs-other", pseudoRoot: "/srv/nfs", mountOptions: []string{"nfsvers=4"}, expectError: true},
+	}
+
+	for _, c := range cases {
+		remotePath, err := resolveRemotePath(c.remotePath, c.pseudoRoot, c.mountOptions)
+		if c.expectError {
+			if err == nil {
+				t.Errorf("expect got error when resolve %s against pseudo root %s", c.remotePath, c.pseudoRoot)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("got error when resolve %s against pseudo root %s: %v", c.remotePath, c.pseudoRoot, err)
+		}
+		if remotePath != c.expected {
+			t.Errorf("expected remote path %s, got %s", c.expected, remotePath)
+		}
+	}
+}
+
+func TestNFSDriverCreateDefaultOptions(t *testing.T) {
+	propagatedMountpoint := path.Join(os.TempDir(), "net-volume-nfs-default-options-test")
+	driver, err := New(context.Background(), log.New("test-nfs"), "nfs", propagatedMountpoint, `{
+		"address": "nfs-server.mock",
+		"remotePath": "/mock",
+		"purgeAfterDelete": true,
+		"ignoredVolumeOptions": ["com.example.injected"]
+	}`)
+	if err != nil {
+		t.Fatalf("got error when new nfs driver: %v", err)
+	}
+	defer func() {
+		if err := driver.Destroy(); err != nil {
+			t.Errorf("got error when destroy nfs driver: %v", err)
+		}
+		if err := os.RemoveAll(propagatedMountpoint); err != nil {
+			t.Errorf("got error when remove propagated mountpoint %s: %v", propagatedMountpoint, err)
+		}
+	}()
+
+	cases := map[string]map[string]string{
+		"nil-options":     nil,
+		"empty-options":   {},
+		"ignored-options": {"com.example.injected": "value"},
+	}
+	for name, options := range cases {
+		err = driver.Create(name, options)
+		if err != nil {
+			t.Fatalf("got error when create volume %s: %v", name, err)
+		}
+
+		volumeMetadata, err := driver.Get(name)
+		if err != nil {
+			t.Fatalf("got error when get volume %s: %v", name, err)
+		}
+		if !volumeMetadata.Spec.PurgeAfterDelete {
+			t.Errorf("expected volume %s to inherit purgeAfterDelete from driver options", name)
+		}
+	}
+
+	err = driver.Create("unknown-options", map[string]string{"unknown": "value"})
+	if err == nil {
+		t.Fatalf("expect got error when create volume with unknown option")
+	}
+}
+
+func TestNFSDriverDataPathIsFile(t *testing.T) {
+	propagatedMountpoint := path.Join(os.TempDir(), "net-volume-nfs-data-file-test")
+	driver, err := New(context.Background(), log.New("test-nfs"), "nfs", propagatedMountpoint, localNFSServerDriverOptions)
+	if err != nil {
+		t.Fatalf("got error when new nfs driver: %v", err)
+	}
+	defer func() {
+		if err := driver.Destroy(); err != nil {
+			t.Errorf("got error when destroy nfs driver: %v", err)
+		}
+		if err := os.RemoveAll(propagatedMountpoint); err != nil {
+			t.Errorf("got error when remove propagated mountpoint %s: %v", propagatedMountpoint, err)
+		}
+	}()
+
+	// Test Create with a file at the data path
+	err = os.MkdirAll(path.Join(propagatedMountpoint, "file"), 0755)
+	if err != nil {
+		t.Fatalf("got error when create volume directory: %v", err)
+	}
+	err = os.WriteFile(path.Join(propagatedMountpoint, "file", "_data"), []byte("data"), 0644)
+	if err != nil {
+		t.Fatalf("got error when write file at data path: %v", err)
+	}
+	err = driver.Create("file", nil)
+	if err == nil || !strings.Contains(err.Error(), "not a directory") {
+		t.Fatalf("expect got not a directory error when create volume with file data path, got %v", err)
+	}
+
+	// Test Mount after the data path is replaced by a file
+	err = driver.Create("replaced", nil)
+	if err != nil {
+		t.Fatalf("got error when create volume replaced: %v", err)
+	}
+	dataPath := path.Join(propagatedMountpoint, "replaced", "_data")
+	err = os.Remove(dataPath)
+	if err != nil {
+		t.Fatalf("got error when remove data path: %v", err)
+	}
+	err = os.WriteFile(dataPath, []byte("data"), 0644)
+	if err != nil {
+		t.Fatalf("got error when write file at data path: %v", err)
+	}
+	_, err = driver.Mount("replaced", "1")
+	if err == nil || !strings.Contains(err.Error(), "not a directory") {
+		t.Fatalf("expect got not a directory error when mount volume with file data path, got %v", err)
+	}
+}
+
+func TestRunTwoPhase(t *testing.T) {
+	steps := []string{}
+	change := fsChange{
+		Stage:    func() error { steps = append(steps, "stage"); return nil },
+		Commit:   func() error { steps = append(steps, "commit"); return nil },
+		Rollback: func() error { steps = append(steps, "rollback"); return nil },
+	}
+
+	err := runTwoPhase(change, func() error { steps = append(steps, "txn"); return nil })
+	if err != nil {
+		t.Fatalf("got error when run two phase change: %v", err)
+	}
+	if strings.Join(steps, ",") != "stage,txn,commit" {
+		t.Errorf("expected stage,txn,commit, got %v", steps)
+	}
+
+	steps = []string{}
+	txnErr := errors.New("txn failed")
+	err = runTwoPhase(change, func() error { steps = append(steps, "txn"); return txnErr })
+	if !errors.Is(err, txnErr) {
+		t.Fatalf("expected txn error, got %v", err)
+	}
+	if strings.Join(steps, ",") != "stage,txn,rollback" {
+		t.Errorf("expected stage,txn,rollback, got %v", steps)
+	}
+}
+
+func TestNFSDriverCreateRollback(t *testing.T) {
+	propagatedMountpoint := path.Join(os.TempDir(), "net-volume-nfs-rollback-test")
+	driver, err := New(context.Background(), log.New("test-nfs"), "nfs", propagatedMountpoint, localNFSServerDriverOptions)
+	if err != nil {
+		t.Fatalf("got error when new nfs driver: %v", err)
+	}
+	defer func() {
+		if err := driver.Destroy(); err != nil {
+			t.Errorf("got error when destroy nfs driver: %v", err)
+		}
+		if err := os.RemoveAll(propagatedMountpoint); err != nil {
+			t.Errorf("got error when remove propagated mountpoint %s: %v", propagatedMountpoint, err)
+		}
+	}()
+
+	err = driver.Create("test", nil)
+	if err != nil {
+		t.Fatalf("got error when create volume test: %v", err)
+	}
+	dataFile := path.Join(propagatedMountpoint, "test", "_data", "file")
+	err = os.WriteFile(dataFile, []byte("data"), 0644)
+	if err != nil {
+		t.Fatalf("got error when write volume data: %v", err)
+	}
+
+	// A failed metadata transaction must not roll back a directory it did not create
+	err = driver.Create("test", nil)
+	if err == nil {
+		t.Fatalf("expect got error when create volume test twice")
+	}
+	_, err = os.Stat(dataFile)
+	if err != nil {
+		t.Errorf("expected volume data to survive a failed create, got %v", err)
+	}
+}
+
+func TestBackgroundMountOptions(t *testing.T) {
+	mountOptions := []string{"nfsvers=4", "fg", "rw"}
+	options := backgroundMountOptions(mountOptions)
+	if strings.Join(options, ",") != "nfsvers=4,bg,rw" {
+		t.Errorf("expected nfsvers=4,bg,rw, got %v", options)
+	}
+	if strings.Join(mountOptions, ",") != "nfsvers=4,fg,rw" {
+		t.Errorf("expected input mount options to be untouched, got %v", mountOptions)
+	}
+}
+
+func TestNFSDriverMountWebhook(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		request := &mountAdmissionRequest{}
+		if err := json.NewDecoder(r.Body).Decode(request); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if request.ID == "deny" {
+			_ = json.NewEncoder(w).Encode(&mountAdmissionResponse{Allowed: false, Reason: "denied by test"})
+			return
+		}
+		_ = json.NewEncoder(w).Encode(&mountAdmissionResponse{Allowed: true})
+	}))
+	defer server.Close()
+
+	failOpenDriver, _ := newTestNFSDriver(t, fmt.Sprintf(`{
+		"address": "nfs-server.mock",
+		"remotePath": "/mock",
+		"mountWebhook": {"url": "%s", "timeout": "1s"}
+	}`, server.URL))
+	err := failOpenDriver.Create("test", nil)
+	if err != nil {
+		t.Fatalf("got error when create volume test: %v", err)
+	}
+
+	// Test webhook denial
+	_, err = failOpenDriver.Mount("test", "deny")
+	if err == nil || !strings.Contains(err.Error(), "denied by test") {
+		t.Fatalf("expect got denied error when mount volume test, got %v", err)
+	}
+
+	// Test webhook approval
+	_, err = failOpenDriver.Mount("test", "1")
+	if err != nil {
+		t.Fatalf("got error when mount volume test: %v", err)
+	}
+	err = failOpenDriver.Unmount("test", "1")
+	if err != nil {
+		t.Fatalf("got error when unmount volume test: %v", err)
+	}
+
+	// Test fail-open when the webhook is down
+	server.Close()
+	_, err = failOpenDriver.Mount("test", "2")
+	if err != nil {
+		t.Fatalf("expected mount to be allowed when webhook is down, got %v", err)
+	}
+
+	// Test fail-closed when the webhook is down
+	failClosedDriver, _ := newTestNFSDriver(t, fmt.Sprintf(`{
+		"address": "nfs-server.mock",
+		"remotePath": "/mock",
+		"mountWebhook": {"url": "%s", "timeout": "1s", "failClosed": true}
+	}`, server.URL))
+	err = failClosedDriver.Create("test", nil)
+	if err != nil {
+		t.Fatalf("got error when create volume test: %v", err)
+	}
+	_, err = failClosedDriver.Mount("test", "1")
+	if err == nil {
+		t.Fatalf("expect got error when mount volume test with fail-closed webhook down")
+	}
+}
+
+func TestNFSDriverNestedNames(t *testing.T) {
+	driver, propagatedMountpoint := newTestNFSDriver(t, `{
+		"address": "nfs-server.mock",
+		"remotePath": "/mock",
+		"purgeAfterDelete": true,
+		"allowNestedNames": true
+	}`)
+
+	err := driver.Create("team/project/vol", nil)
+	if err != nil {
+		t.Fatalf("got error when create nested volume: %v", err)
+	}
+	_, err = os.Stat(path.Join(propagatedMountpoint, "team", "project", "vol", "_data"))
+	if err != nil {
+		t.Fatalf("expected nested data directory to exist, got %v", err)
+	}
+
+	invalidNames := []string{
+		"team/../escape",
+		"team//vol",
+		"metadata.db/vol",
+		"team/project/vol/_data/inner",
+		"team/project",
+		"team/project/vol/inner",
+	}
+	for _, name := range invalidNames {
+		err = driver.Create(name, nil)
+		if err == nil {
+			t.Errorf("expect got error when create volume %s", name)
+		}
+	}
+
+	err = driver.Remove("team/project/vol")
+	if err != nil {
+		t.Fatalf("got error when remove nested volume: %v", err)
+	}
+	_, err = os.Stat(path.Join(propagatedMountpoint, "team"))
+	if !os.IsNotExist(err) {
+		t.Errorf("expected empty parent directories to be removed, got %v", err)
+	}
+}
+
+func TestCoherentMountOptions(t *testing.T) {
+	options := coherentMountOptions([]string{"nfsvers=4", "actimeo=30", "rw", "lookupcache=all"})
+	if strings.Join(options, ",") != "nfsvers=4,rw,noac,lookupcache=none,nosharecache" {
+		t.Errorf("expected nfsvers=4,rw,noac,lookupcache=none,nosharecache, got %v", options)
+	}
+}
+
+func TestNFSDriverWarmupOnMount(t *testing.T) {
+	driver, propagatedMountpoint := newTestNFSDriver(t, `{
+		"address": "nfs-server.mock",
+		"remotePath": "/mock",
+		"warmupMaxBytes": 3072
+	}`)
+
+	for _, name := range []string{"small", "large"} {
+		err := driver.Create(name, map[string]string{"warmupOnMount": "true"})
+		if err != nil {
+			t.Fatalf("got error when create volume %s: %v", name, err)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		err := os.WriteFile(path.Join(propagatedMountpoint, "small", "_data", fmt.Sprintf("file-%d", i)), make([]byte, 1024), 0644)
+		if err != nil {
+			t.Fatalf("got error when write volume data: %v", err)
+		}
+	}
+	err := os.WriteFile(path.Join(propagatedMountpoint, "large", "_data", "file"), make([]byte, 4096), 0644)
+	if err != nil {
+		t.Fatalf("got error when write volume data: %v", err)
+	}
+
+	expected := map[string]*apis.WarmupStatus{
+		"small": {State: warmupCompleted, Bytes: 2048},
+		"large": {State: warmupLimited, Bytes: 3072},
+	}
+	for name, expectedStatus := range expected {
+		_, err = driver.Mount(name, "1")
+		if err != nil {
+			t.Fatalf("got error when mount volume %s: %v", name, err)
+		}
+
+		var warmupStatus *apis.WarmupStatus
+		for i := 0; i < 100; i++ {
+			volumeMetadata, err := driver.Get(name)
+			if err != nil {
+				t.Fatalf("got error when get volume %s: %v", name, err)
+			}
+			warmupStatus = volumeMetadata.Status.Warmup
+			if warmupStatus != nil && warmupStatus.State != warmupRunning {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		if warmupStatus == nil || warmupStatus.State != expectedStatus.State || warmupStatus.Bytes != expectedStatus.Bytes {
+			t.Errorf("expected warmup %s with %d bytes for volume %s, got %+v", expectedStatus.State, expectedStatus.Bytes, name, warmupStatus)
+		}
+	}
+}
+
+func TestBackendError(t *testing.T) {
+	err := backendError(fmt.Errorf("failed to create volume data directory: %w", &os.PathError{Op: "mkdir", Path: "/mock", Err: syscall.ENOSPC}))
+	if !errors.Is(err, apis.ErrBackendFull) {
+		t.Errorf("expected backend full error, got %v", err)
+	}
+
+	err = backendError(os.ErrPermission)
+	if errors.Is(err, apis.ErrBackendFull) {
+		t.Errorf("expected permission error to be kept, got %v", err)
+	}
+}
+
+func TestNFSDriverBackendData(t *testing.T) {
+	driver, _ := newTestNFSDriver(t, localNFSServerDriverOptions)
+
+	err := driver.Create("test", nil)
+	if err != nil {
+		t.Fatalf("got error when create volume test: %v", err)
+	}
+	_, err = driver.Mount("test", "1")
+	if err != nil {
+		t.Fatalf("got error when mount volume test: %v", err)
+	}
+
+	// Backend data must round-trip untouched through metadata updates
+	volumeMetadata, err := driver.Get("test")
+	if err != nil {
+		t.Fatalf("got error when get volume test: %v", err)
+	}
+	backendData := &nfsBackendData{}
+	err = json.Unmarshal(volumeMetadata.BackendData, backendData)
+	if err != nil {
+		t.Fatalf("got error when parse backend data %s: %v", string(volumeMetadata.BackendData), err)
+	}
+	if backendData.Export != "nfs-server.mock:/mock" {
+		t.Errorf("expected export nfs-server.mock:/mock in backend data, got %s", backendData.Export)
+	}
+}
+
+func TestNFSDriverCreatedAt(t *testing.T) {
+	driver, _ := newTestNFSDriver(t, localNFSServerDriverOptions)
+
+	err := driver.Create("imported", map[string]string{"createdAt": "2024-01-02T03:04:05Z"})
+	if err != nil {
+		t.Fatalf("got error when create volume imported: %v", err)
+	}
+	volumeMetadata, err := driver.Get("imported")
+	if err != nil {
+		t.Fatalf("got error when get volume imported: %v", err)
+	}
+	expected := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	if !volumeMetadata.CreatedAt.Equal(expected) {
+		t.Errorf("expected createdAt %s, got %s", expected, volumeMetadata.CreatedAt)
+	}
+
+	err = driver.Create("malformed", map[string]string{"createdAt": "yesterday"})
+	if err == nil {
+		t.Fatalf("expect got error when create volume with malformed createdAt")
+	}
+	err = driver.Create("future", map[string]string{"createdAt": time.Now().Add(time.Hour).Format(time.RFC3339)})
+	if err == nil {
+		t.Fatalf("expect got error when create volume with future createdAt")
+	}
+}
+
+func TestNFSDriverMetadataLockPath(t *testing.T) {
+	lockPath := path.Join(t.TempDir(), "local", "metadata.db.lock")
+	driver, propagatedMountpoint := newTestNFSDriver(t, fmt.Sprintf(`{
+		"address": "nfs-server.mock",
+		"remotePath": "/mock",
+		"metadataLockPath": "%s"
+	}`, lockPath))
+
+	err := driver.Create("test", nil)
+	if err != nil {
+		t.Fatalf("got error when create volume test: %v", err)
+	}
+
+	_, err = os.Stat(lockPath)
+	if err != nil {
+		t.Errorf("expected lock file at %s, got %v", lockPath, err)
+	}
+	_, err = os.Stat(path.Join(propagatedMountpoint, "metadata.db.lock"))
+	if !os.IsNotExist(err) {
+		t.Errorf("expected no lock file on the share, got %v", err)
+	}
+}
+
+func TestNFSDriverListSkipsReservedEntries(t *testing.T) {
+	driver, _ := newTestNFSDriver(t, localNFSServerDriverOptions)
+
+	err := driver.Create("test", nil)
+	if err != nil {
+		t.Fatalf("got error when create volume test: %v", err)
+	}
+
+	// Write stray entries directly to the store, bypassing volume name validation
+	for _, name := range []string{"metadata.db", "metadata.db.lock", "../escape"} {
+		err = driver.(*nfs).db.CreateVolumeMetadata(name, func(volumeMetadata *apis.VolumeMetadata) error {
+			*volumeMetadata = apis.VolumeMetadata{Spec: &apis.VolumeSpec{}, Status: &apis.VolumeStatus{}}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("got error when write stray entry %s: %v", name, err)
+		}
+	}
+
+	volumeMetadataMap, err := driver.List()
+	if err != nil {
+		t.Fatalf("got error when list volumes: %v", err)
+	}
+	if len(volumeMetadataMap) != 1 || volumeMetadataMap["test"] == nil {
+		t.Errorf("expected only volume test to be listed, got %v", volumeMetadataMap)
+	}
+}
+
+func TestNFSDriverMetadataRecovery(t *testing.T) {
+	// Copy the database while it is open to get the image a node leaves when it dies mid-write,
+	// small tables keep the preallocated log files cheap to copy
+	livePath := t.TempDir()
+	db, err := badgerdb.Open(badgerdb.DefaultOptions(livePath).WithLogger(nil).WithMemTableSize(8 << 20).WithValueLogFileSize(1 << 20))
+	if err != nil {
+		t.Fatalf("got error when open badger database: %v", err)
+	}
+	err = db.Update(func(txn *badgerdb.Txn) error {
+		return txn.Set([]byte("test"), []byte(`{"mountpoint":"test/_data","spec":{},"status":{}}`))
+	})
+	if err != nil {
+		t.Fatalf("got error when write badger database: %v", err)
+	}
+	crashedMountpoints := []string{t.TempDir(), t.TempDir()}
+	for _, propagatedMountpoint := range crashedMountpoints {
+		err = os.CopyFS(path.Join(propagatedMountpoint, "metadata.db"), os.DirFS(livePath))
+		if err != nil {
+			t.Fatalf("got error when copy badger database: %v", err)
+		}
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("got error when close badger database: %v", err)
+	}
+
+	_, err = New(context.Background(), log.New("test-nfs").WithLogLevel(log.ErrorLevel), "nfs", crashedMountpoints[0], `{
+		"address": "nfs-server.mock",
+		"remotePath": "/mock",
+		"truncateMetadataOnRecovery": false
+	}`)
+	if err == nil {
+		t.Fatalf("expect got error when new nfs driver on crashed metadata without truncation")
+	}
+
+	driver, err := New(context.Background(), log.New("test-nfs").WithLogLevel(log.ErrorLevel), "nfs", crashedMountpoints[1], localNFSServerDriverOptions)
+	if err != nil {
+		t.Fatalf("got error when new nfs driver on crashed metadata: %v", err)
+	}
+	defer func() {
+		if err := driver.Destroy(); err != nil {
+			t.Errorf("got error when destroy nfs driver: %v", err)
+		}
+	}()
+	_, err = driver.Get("test")
+	if err != nil {
+		t.Fatalf("got error when get volume test after recovery: %v", err)
+	}
+}
+
+func TestWriteProbe(t *testing.T) {
+	dir := t.TempDir()
+	err := writeProbe(dir)
+	if err != nil {
+		t.Fatalf("got error when probe %s: %v", dir, err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("got error when read %s: %v", dir, err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("expected write probe to clean up, got %d entries", len(entries))
+	}
+
+	err = writeProbe(path.Join(dir, "missing"))
+	if err == nil {
+		t.Fatalf("expect got error when probe missing directory")
+	}
+}
+
+func TestDefaultNFSMountOptions(t *testing.T) {
+	cases := map[string]string{
+		"":    "nfsvers=4,rw,noatime,rsize=8192,wsize=8192,tcp,timeo=14,sync",
+		"4":   "nfsvers=4,rw,noatime,rsize=8192,wsize=8192,tcp,timeo=14,sync",
+		"4.1": "nfsvers=4.1,rw,noatime,rsize=8192,wsize=8192,tcp,timeo=14,sync",
+		"3":   "nfsvers=3,rw,noatime,rsize=32768,wsize=32768,tcp,timeo=600,retrans=2,hard,sync",
+	}
+	for version, expected := range cases {
+		mountOptions, err := defaultNFSMountOptions(version)
+		if err != nil {
+			t.Fatalf("got error when get default mount options of NFS version %s: %v", version, err)
+		}
+		if strings.Join(mountOptions, ",") != expected {
+			t.Errorf("expected %s for NFS version %s, got %v", expected, version, mountOptions)
+		}
+	}
+
+	_, err := defaultNFSMountOptions("2")
+	if err == nil {
+		t.Fatalf("expect got error when get default mount options of NFS version 2")
+	}
+}
+
+func TestNFSDriverHistory(t *testing.T) {
+	driver, _ := newTestNFSDriver(t, `{
+		"address": "nfs-server.mock",
+		"remotePath": "/mock",
+		"historyDepth": 3,
+		"nodeID": "node-a"
+	}`)
+
+	err := driver.Create("test", nil)
+	if err != nil {
+		t.Fatalf("got error when create volume test: %v", err)
+	}
+	_, err = driver.Mount("test", "1")
+	if err != nil {
+		t.Fatalf("got error when mount volume test: %v", err)
+	}
+	// A rejected change must not be recorded
+	_, err = driver.Mount("test", "1")
+	if err == nil {
+		t.Fatalf("expected error when mount volume test twice")
+	}
+	err = driver.Unmount("test", "1")
+	if err != nil {
+		t.Fatalf("got error when unmount volume test: %v", err)
+	}
+
+	history, err := driver.History("test")
+	if err != nil {
+		t.Fatalf("got error when get history of volume test: %v", err)
+	}
+	if len(history) != 3 {
+		t.Fatalf("expected 3 history entries, got %d", len(history))
+	}
+	for i, operation := range []string{historyCreate, historyMount, historyUnmount} {
+		if history[i].Version != i+1 || history[i].Operation != operation {
+			t.Errorf("expected entry %d to be version %d of %s, got version %d of %s", i, i+1, operation, history[i].Version, history[i].Operation)
+		}
+	}
+	changes := history[1].Changes
+	if history[1].By != "1" || len(changes) != 3 || !strings.HasPrefix(changes[0], `status.mountBy: none -> ["1"]`) ||
+		!strings.HasPrefix(changes[1], `status.mountClaimedAt: none -> {"1":`) || changes[2] != `status.mountNodes: none -> {"1":"node-a"}` {
+		t.Errorf("expected mount by 1 changing mountBy, mountClaimedAt and mountNodes, got %s %v", history[1].By, changes)
+	}
+
+	// Only the newest historyDepth entries are kept
+	err = driver.Remove("test")
+	if err != nil {
+		t.Fatalf("got error when remove volume test: %v", err)
+	}
+	history, err = driver.History("test")
+	if err != nil {
+		t.Fatalf("got error when get history of volume test: %v", err)
+	}
+	if len(history) != 3 || history[0].Version != 2 || history[2].Operation != historyRemove {
+		t.Errorf("expected history pruned to versions 2 to 4 ending with remove, got %d entries", len(history))
+	}
+
+	// History entries must not be listed as volumes
+	volumeMetadataMap, err := driver.List()
+	if err != nil {
+		t.Fatalf("got error when list volumes: %v", err)
+	}
+	if len(volumeMetadataMap) != 0 {
+		t.Errorf("expected no volume, got %d", len(volumeMetadataMap))
+	}
+}
+
+func TestNFSDriverMetadataReplica(t *testing.T) {
+	replicaPath := path.Join(t.TempDir(), "replica", "metadata.backup")
+	driverOptions := func(promote bool) string {
+		return fmt.Sprintf(`{
+			"address": "nfs-server.mock",
+			"remotePath": "/mock",
+			"metadataReplicaPath": "%s",
+			"promoteMetadataReplica": %t
+		}`, replicaPath, promote)
+	}
+
+	primary, err := New(context.Background(), log.New("test-nfs").WithLogLevel(log.WarnLevel), "nfs", t.TempDir(), driverOptions(false))
+	if err != nil {
+		t.Fatalf("got error when new nfs driver: %v", err)
+	}
+	err = primary.Create("test", nil)
+	if err != nil {
+		t.Fatalf("got error when create volume test: %v", err)
+	}
+	// Destroy waits for the pending replication
+	err = primary.Destroy()
+	if err != nil {
+		t.Fatalf("got error when destroy nfs driver: %v", err)
+	}
+
+	// An empty share stands in for a lost database, the newer replica is only promoted on request
+	driver, _ := newTestNFSDriver(t, driverOptions(false))
+	volumeMetadataMap, err := driver.List()
+	if err != nil {
+		t.Fatalf("got error when list volumes: %v", err)
+	}
+	if len(volumeMetadataMap) != 0 {
+		t.Errorf("expected replica not to be promoted, got %d volumes", len(volumeMetadataMap))
+	}
+
+	driver, _ = newTestNFSDriver(t, driverOptions(true))
+	volumeMetadataMap, err = driver.List()
+	if err != nil {
+		t.Fatalf("got error when list volumes: %v", err)
+	}
+	if _, ok := volumeMetadataMap["test"]; !ok || len(volumeMetadataMap) != 1 {
+		t.Errorf("expected volume test promoted from replica, got %d volumes", len(volumeMetadataMap))
+	}
+}
+
+func TestNFSDriverInvalidRemotePath(t *testing.T) {
+	for _, driverOptions := range []string{
+		`{"address": "nfs-server.mock"}`,
+		`{"address": "nfs-server.mock", "remotePath": ""}`,
+		`{"address": "nfs-server.mock", "remotePath": "mock"}`,
+	} {
+		_, err := New(context.Background(), log.New("test-nfs").WithLogLevel(log.WarnLevel), "nfs", t.TempDir(), driverOptions)
+		if err == nil || !strings.Contains(err.Error(), "remotePath") {
+			t.Errorf("expected remotePath error for driver options %s, got %v", driverOptions, err)
+		}
+	}
+}
+
+func TestNFSDriverInvalidOptionsBeforeMount(t *testing.T) {
+	// The address is never reachable, so any of these options mounting the share first fails with a mount error
+	for option, driverOptions := range map[string]string{
+		"purgeRetryInterval":    `{"address": "192.0.2.1", "remotePath": "/", "purgeRetryInterval": "1"}`,
+		"warmupTimeout":         `{"address": "192.0.2.1", "remotePath": "/", "warmupTimeout": "1"}`,
+		"usageTTL":              `{"address": "192.0.2.1", "remotePath": "/", "usageTTL": "1"}`,
+		"healthCheckInterval":   `{"address": "192.0.2.1", "remotePath": "/", "healthCheckInterval": "1"}`,
+		"healthCheckMaxBackoff": `{"address": "192.0.2.1", "remotePath": "/", "healthCheckMaxBackoff": "1"}`,
+		"healthCheckTimeout":    `{"address": "192.0.2.1", "remotePath": "/", "healthCheckTimeout": "1"}`,
+		"claimTTL":              `{"address": "192.0.2.1", "remotePath": "/", "claimTTL": "1"}`,
+		"nodeID":                `{"address": "192.0.2.1", "remotePath": "/", "nodeID": "node/1"}`,
+		"metadataStore":         `{"address": "192.0.2.1", "remotePath": "/", "metadataStore": "sqlite"}`,
+		"metadataReplicaPath":   `{"address": "192.0.2.1", "remotePath": "/", "metadataStore": "jsonfile", "metadataReplicaPath": "/tmp/replica"}`,
+		"mount webhook":         `{"address": "192.0.2.1", "remotePath": "/", "mountWebhook": {}}`,
+	} {
+		propagatedMountpoint := path.Join(t.TempDir(), "mnt")
+		_, err := New(context.Background(), log.New("test-nfs").WithLogLevel(log.WarnLevel), "nfs", propagatedMountpoint, driverOptions)
+		if err == nil || !strings.Contains(err.Error(), option) {
+			t.Errorf("expected %s error for driver options %s, got %v", option, driverOptions, err)
+		}
+		_, err = os.Stat(propagatedMountpoint)
+		if !os.IsNotExist(err) {
+			t.Errorf("expected no mount point created for driver options %s, got %v", driverOptions, err)
+		}
+	}
+}
+
+func TestNFSDriverDestroyTwice(t *testing.T) {
+	driver, _ := newTestNFSDriver(t, localNFSServerDriverOptions)
+
+	// Concurrent calls, as from a repeated SIGTERM, share a single teardown
+	errs := make([]error, 2)
+	wg := sync.WaitGroup{}
+	for i := range errs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = driver.Destroy()
+		}()
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("got error from destroy call %d: %v", i, err)
+		}
+	}
+	err := driver.Create("test", nil)
+	if !errors.Is(err, apis.ErrShuttingDown) {
+		t.Errorf("expected %v when create after destroy, got %v", apis.ErrShuttingDown, err)
+	}
+}
+
+func TestMountIDsUnmarshal(t *testing.T) {
+	cases := []struct {
+		data     string
+		expected apis.MountIDs
+	}{
+		{data: `{}`, expected: nil},
+		{data: `{"mountBy": ""}`, expected: nil},
+		{data: `{"mountBy": "1"}`, expected: apis.MountIDs{"1"}},
+		{data: `{"mountBy": ["1", "2"]}`, expected: apis.MountIDs{"1", "2"}},
+	}
+	for _, c := range cases {
+		volumeStatus := &apis.VolumeStatus{}
+		err := json.Unmarshal([]byte(c.data), volumeStatus)
+		if err != nil {
+			t.Errorf("got error when unmarshal volume status %s: %v", c.data, err)
+			continue
+		}
+		if !slices.Equal(volumeStatus.MountBy, c.expected) {
+			t.Errorf("expected mountBy %v for %s, got %v", c.expected, c.data, volumeStatus.MountBy)
+		}
+	}
+}
+
+func TestNFSDriverUsage(t *testing.T) {
+	driver, propagatedMountpoint := newTestNFSDriver(t, `{
+		"address": "nfs-server.mock",
+		"remotePath": "/mock",
+		"usageTTL": "1h"
+	}`)
+
+	err := driver.Create("test", nil)
+	if err != nil {
+		t.Fatalf("got error when create volume test: %v", err)
+	}
+	dataPath := path.Join(propagatedMountpoint, "test", "_data")
+	err = os.WriteFile(path.Join(dataPath, "file"), make([]byte, 4096), 0644)
+	if err != nil {
+		t.Fatalf("got error when write volume data: %v", err)
+	}
+
+	volumeMetadata, err := driver.Get("test")
+	if err != nil {
+		t.Fatalf("got error when get volume test: %v", err)
+	}
+	usage := volumeMetadata.Status.Usage
+	if usage == nil || usage.UsedBytes != 4096 || usage.TotalBytes == 0 {
+		t.Fatalf("expected 4096 bytes used on a filesystem with capacity, got %+v", usage)
+	}
+
+	// The cached usage is reported until it is older than usageTTL
+	err = os.WriteFile(path.Join(dataPath, "more"), make([]byte, 4096), 0644)
+	if err != nil {
+		t.Fatalf("got error when write volume data: %v", err)
+	}
+	volumeMetadataMap, err := driver.List()
+	if err != nil {
+		t.Fatalf("got error when list volumes: %v", err)
+	}
+	usage = volumeMetadataMap["test"].Status.Usage
+	if usage == nil || usage.UsedBytes != 4096 {
+		t.Errorf("expected cached 4096 bytes used, got %+v", usage)
+	}
+}
+
+func TestNFSDriverSize(t *testing.T) {
+	driver, propagatedMountpoint := newTestNFSDriver(t, `{
+		"address": "nfs-server.mock",
+		"remotePath": "/mock",
+		"usageTTL": "1h"
+	}`)
+
+	// Malformed sizes are rejected at create rather than at mount
+	for _, size := range []string{"0", "10X", "1.5G", "-1G"} {
+		err := driver.Create("invalid", map[string]string{"size": size})
+		if err == nil || !strings.Contains(err.Error(), "size") {
+			t.Errorf("expected size error for %s, got %v", size, err)
+		}
+	}
+
+	err := driver.Create("test", map[string]string{"size": "4K"})
+	if err != nil {
+		t.Fatalf("got error when create volume test: %v", err)
+	}
+	err = os.WriteFile(path.Join(propagatedMountpoint, "test", "_data", "file"), make([]byte, 8192), 0644)
+	if err != nil {
+		t.Fatalf("got error when write volume data: %v", err)
+	}
+
+	volumeMetadata, err := driver.Get("test")
+	if err != nil {
+		t.Fatalf("got error when get volume test: %v", err)
+	}
+	if volumeMetadata.Spec.SizeBytes != 4096 {
+		t.Errorf("expected size of 4096 bytes, got %d", volumeMetadata.Spec.SizeBytes)
+	}
+	usage := volumeMetadata.Status.Usage
+	if usage == nil || usage.LimitBytes != 4096 || !usage.OverQuota {
+		t.Errorf("expected volume test over its limit of 4096 bytes, got %+v", usage)
+	}
+}
+
+func TestProbeMount(t *testing.T) {
+	err := probeMount("/", "/", time.Second)
+	if err != nil {
+		t.Errorf("expected / to be a live mount, got %v", err)
+	}
+	// A plain directory is what is left when the share is gone
+	dir := t.TempDir()
+	err = probeMount(dir, dir, time.Second)
+	if err == nil || !strings.Contains(err.Error(), "not mounted") || !needsRemount(err) {
+		t.Errorf("expected not mounted error needing a remount for a plain directory, got %v", err)
+	}
+	// A missing probe file is not a reason to remount
+	err = probeMount("/", path.Join(dir, "missing"), time.Second)
+	if err == nil || needsRemount(err) {
+		t.Errorf("expected probe error not needing a remount for a missing probe file, got %v", err)
+	}
+
+	for _, err := range []error{syscall.ESTALE, syscall.EIO} {
+		if !needsRemount(fmt.Errorf("failed to stat: %w", &os.PathError{Op: "stat", Path: dir, Err: err})) {
+			t.Errorf("expected %v to need a remount", err)
+		}
+	}
+}
+
+func TestDetachMount(t *testing.T) {
+	// A share that is already gone leaves nothing to detach
+	err := detachMount(t.TempDir())
+	if err != nil {
+		t.Errorf("got error when detach a plain directory: %v", err)
+	}
+}
+
+func TestWithJitter(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		delay := withJitter(10 * time.Second)
+		if delay < 10*time.Second || delay >= 12*time.Second {
+			t.Fatalf("expected jittered delay in [10s, 12s), got %s", delay)
+		}
+	}
+}
+
+func TestNextBackoff(t *testing.T) {
+	backoff := time.Second
+	for _, expected := range []time.Duration{2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		backoff = nextBackoff(backoff, 5*time.Second)
+		if backoff != expected {
+			t.Errorf("expected backoff %s, got %s", expected, backoff)
+		}
+	}
+}
+
+func TestNFSDriverHealthCheckStops(t *testing.T) {
+	n := &nfs{
+		logger:              log.New("test-nfs").WithLogLevel(log.WarnLevel),
+		opts:                &nfsOptions{},
+		rootPath:            t.TempDir(),
+		driverLocks:         newDriverLocks(),
+		healthCheckInterval: time.Hour,
+		healthCheckDone:     make(chan struct{}),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go n.healthCheck(ctx)
+	cancel()
+
+	select {
+	case <-n.healthCheckDone:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected health check to return once its context is canceled")
+	}
+}
+
+func TestNFSDriverDestroyHungHealthCheck(t *testing.T) {
+	driver, _ := newTestNFSDriver(t, `{
+		"address": "nfs-server.mock",
+		"remotePath": "/mock",
+		"healthCheckTimeout": "100ms"
+	}`)
+
+	// A health check hanging on the dead mount never returns
+	n := driver.(*nfs)
+	n.stopHealthCheck = func() {}
+	n.healthCheckDone = make(chan struct{})
+
+	destroyed := make(chan error, 1)
+	go func() {
+		destroyed <- driver.Destroy()
+	}()
+	select {
+	case err := <-destroyed:
+		if err != nil {
+			t.Errorf("got error when destroy nfs driver: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected destroy not blocked by a hung health check")
+	}
+}
+
+func TestNFSDriverDestroyHungUsageCheck(t *testing.T) {
+	driver, _ := newTestNFSDriver(t, `{
+		"address": "nfs-server.mock",
+		"remotePath": "/mock",
+		"healthCheckTimeout": "100ms"
+	}`)
+
+	// A disk usage walk hanging on the dead mount never returns
+	n := driver.(*nfs)
+	n.stopUsageCheck()
+	<-n.usageCheckDone
+	n.usageCheckDone = make(chan struct{})
+
+	destroyed := make(chan error, 1)
+	go func() {
+		destroyed <- driver.Destroy()
+	}()
+	select {
+	case err := <-destroyed:
+		if err != nil {
+			t.Errorf("got error when destroy nfs driver: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected destroy not blocked by a hung usage check")
+	}
+}
+
+func TestNFSDriverUnmountUnavailable(t *testing.T) {
+	driver, _ := newTestNFSDriver(t, `{
+		"address": "nfs-server.mock",
+		"remotePath": "/mock",
+		"historyDepth": 0,
+		"usageTTL": "1h"
+	}`)
+	n := driver.(*nfs)
+
+	for _, name := range []string{"remount", "restart"} {
+		err := driver.Create(name, nil)
+		if err != nil {
+			t.Fatalf("got error when create volume %s: %v", name, err)
+		}
+		_, err = driver.Mount(name, "1")
+		if err != nil {
+			t.Fatalf("got error when mount volume %s: %v", name, err)
+		}
+	}
+
+	// Containers stop while the share is being remounted
+	n.unhealthy.Store(true)
+	for _, name := range []string{"remount", "restart"} {
+		err := driver.Unmount(name, "1")
+		if err != nil {
+			t.Errorf("got error when unmount volume %s while the share is unavailable: %v", name, err)
+		}
+	}
+	_, err := driver.Mount("remount", "2")
+	if err == nil {
+		t.Errorf("expect got error when mount volume remount while the share is unavailable")
+	}
+	n.unhealthy.Store(false)
+
+	// A container restarting with the same id finds its earlier mount released
+	_, err = driver.Mount("restart", "1")
+	if err != nil {
+		t.Errorf("got error when mount volume restart again for 1: %v", err)
+	}
+
+	// The remount releases the others
+	n.releaseAllPendingUnmounts()
+	volume, err := driver.Get("remount")
+	if err != nil {
+		t.Fatalf("got error when get volume remount: %v", err)
+	}
+	if len(volume.Status.MountBy) != 0 {
+		t.Errorf("expected volume remount released once the share is back, got mount by %v", volume.Status.MountBy)
+	}
+	err = driver.Remove("remount")
+	if err != nil {
+		t.Errorf("got error when remove volume remount: %v", err)
+	}
+}
+
+func TestNFSDriverConcurrentVolumes(t *testing.T) {
+	// Every metadata access opens the store, keep the extra writes of history and usage out of this test
+	driver, _ := newTestNFSDriver(t, `{
+		"address": "nfs-server.mock",
+		"remotePath": "/mock",
+		"historyDepth": 0,
+		"usageTTL": "1h"
+	}`)
+
+	err := driver.Create("shared", nil)
+	if err != nil {
+		t.Fatalf("got error when create volume shared: %v", err)
+	}
+
+	const workers = 8
+	errs := make(chan error, workers*8)
+	wg := sync.WaitGroup{}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			name := fmt.Sprintf("volume-%d", i)
+			id := fmt.Sprintf("%d", i)
+			steps := []func() error{
+				func() error { return driver.Create(name, nil) },
+				func() error { _, err := driver.Mount(name, id); return err },
+				func() error { _, err := driver.Get(name); return err },
+				func() error { return driver.Unmount(name, id) },
+				func() error { _, err := driver.Mount("shared", id); return err },
+				func() error { _, err := driver.List(); return err },
+			}
+			for _, step := range steps {
+				if err := step(); err != nil {
+					errs <- err
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("got error from concurrent operation: %v", err)
+	}
+
+	volumeMetadataMap, err := driver.List()
+	if err != nil {
+		t.Fatalf("got error when list volumes: %v", err)
+	}
+	if len(volumeMetadataMap) != workers+1 {
+		t.Errorf("expected %d volumes, got %d", workers+1, len(volumeMetadataMap))
+	}
+	for name, volumeMetadata := range volumeMetadataMap {
+		if name != "shared" && len(volumeMetadata.Status.MountBy) != 0 {
+			t.Errorf("expected volume %s unmounted, got mounted by %v", name, volumeMetadata.Status.MountBy)
+		}
+	}
+	// Every mount of the shared volume must have been recorded
+	if mountBy := volumeMetadataMap["shared"].Status.MountBy; len(mountBy) != workers {
+		t.Errorf("expected volume shared mounted by %d ids, got %v", workers, mountBy)
+	}
+}
+
+func TestNFSDriverReadOnlyAndSubPath(t *testing.T) {
+	driver, propagatedMountpoint := newTestNFSDriver(t, localNFSServerDriverOptions)
+
+	err := driver.Create("readonly", map[string]string{"readOnly": "true"})
+	if err != nil {
+		t.Fatalf("got error when create volume readonly: %v", err)
+	}
+	volumeMetadata, err := driver.Get("readonly")
+	if err != nil {
+		t.Fatalf("got error when get volume readonly: %v", err)
+	}
+	if !volumeMetadata.Spec.ReadOnly {
+		t.Errorf("expected volume readonly to be read-only")
+	}
+	mountpoint, err := driver.Mount("readonly", "1")
+	if err != nil {
+		t.Fatalf("got error when mount volume readonly: %v", err)
+	}
+	if mountpoint != path.Join(propagatedMountpoint, "readonly", "_mounts", "1") {
+		t.Errorf("expected read-only mountpoint %s, got %s", path.Join(propagatedMountpoint, "readonly", "_mounts", "1"), mountpoint)
+	}
+
+	err = driver.Create("sub", map[string]string{"subPath": "app/logs"})
+	if err != nil {
+		t.Fatalf("got error when create volume sub: %v", err)
+	}
+	mountpoint, err = driver.Mount("sub", "1")
+	if err != nil {
+		t.Fatalf("got error when mount volume sub: %v", err)
+	}
+	if mountpoint != path.Join(propagatedMountpoint, "sub", "_mounts", "1") {
+		t.Errorf("expected sub path mountpoint %s, got %s", path.Join(propagatedMountpoint, "sub", "_mounts", "1"), mountpoint)
+	}
+	info, err := os.Stat(path.Join(propagatedMountpoint, "sub", "_data", "app", "logs"))
+	if err != nil || !info.IsDir() {
+		t.Errorf("expected sub path directory to be created, got %v", err)
+	}
+	volumePath, err := driver.Path("sub")
+	if err != nil || volumePath != path.Join(propagatedMountpoint, "sub", "_data", "app", "logs") {
+		t.Errorf("expected path %s, got %s, %v", path.Join(propagatedMountpoint, "sub", "_data", "app", "logs"), volumePath, err)
+	}
+
+	// A symlink planted in the data must not lead the sub path out of it
+	err = driver.Create("link", map[string]string{"subPath": "link"})
+	if err != nil {
+		t.Fatalf("got error when create volume link: %v", err)
+	}
+	err = os.Symlink(t.TempDir(), path.Join(propagatedMountpoint, "link", "_data", "link"))
+	if err != nil {
+		t.Fatalf("got error when create symlink: %v", err)
+	}
+	_, err = driver.Mount("link", "1")
+	if err == nil || !strings.Contains(err.Error(), "outside") {
+		t.Errorf("expected sub path outside error when mount volume link, got %v", err)
+	}
+
+	for _, subPath := range []string{"../metadata.db", "/etc", "app/../../escape"} {
+		err = driver.Create("escape", map[string]string{"subPath": subPath})
+		if err == nil || !strings.Contains(err.Error(), "subPath") {
+			t.Errorf("expected subPath error for %s, got %v", subPath, err)
+		}
+	}
+
+	err = driver.Create("test", map[string]string{"unknown": "true"})
+	if err == nil || !strings.Contains(err.Error(), "unknown option") {
+		t.Errorf("expected unknown option error, got %v", err)
+	}
+}
+
+func TestNFSDriverMountSameID(t *testing.T) {
+	driver, _ := newTestNFSDriver(t, localNFSServerDriverOptions)
+
+	err := driver.Create("test", nil)
+	if err != nil {
+		t.Fatalf("got error when create volume test: %v", err)
+	}
+	for _, id := range []string{"1", "2"} {
+		_, err = driver.Mount("test", id)
+		if err != nil {
+			t.Fatalf("got error when mount volume test for %s: %v", id, err)
+		}
+	}
+
+	// A repeated mount is rejected and not counted
+	_, err = driver.Mount("test", "1")
+	if err == nil {
+		t.Fatalf("expect got error when mount volume test twice for 1")
+	}
+	// An unknown id leaves the other mounts in place
+	err = driver.Unmount("test", "unknown")
+	if err == nil {
+		t.Fatalf("expect got error when unmount volume test for unknown id")
+	}
+	err = driver.Unmount("test", "1")
+	if err != nil {
+		t.Fatalf("got error when unmount volume test for 1: %v", err)
+	}
+
+	volumeMetadata, err := driver.Get("test")
+	if err != nil {
+		t.Fatalf("got error when get volume test: %v", err)
+	}
+	if !slices.Equal(volumeMetadata.Status.MountBy, apis.MountIDs{"2"}) {
+		t.Errorf("expected volume test mounted by 2 only, got %v", volumeMetadata.Status.MountBy)
+	}
+}
+
+func TestNFSDriverPerContainerMounts(t *testing.T) {
+	driver, propagatedMountpoint := newTestNFSDriver(t, localNFSServerDriverOptions)
+
+	err := driver.Create("test", map[string]string{"readonly": "true"})
+	if err != nil {
+		t.Fatalf("got error when create volume test: %v", err)
+	}
+	for _, id := range []string{"1", "2"} {
+		mountpoint, err := driver.Mount("test", id)
+		if err != nil {
+			t.Fatalf("got error when mount volume test for %s: %v", id, err)
+		}
+		if mountpoint != path.Join(propagatedMountpoint, "test", "_mounts", id) {
+			t.Errorf("expected mountpoint %s, got %s", path.Join(propagatedMountpoint, "test", "_mounts", id), mountpoint)
+		}
+	}
+	for _, id := range []string{"", "..", "a/b"} {
+		_, err = driver.Mount("test", id)
+		if err == nil || !strings.Contains(err.Error(), "mount id") {
+			t.Errorf("expected invalid mount id error for %q, got %v", id, err)
+		}
+	}
+
+	err = driver.Unmount("test", "1")
+	if err != nil {
+		t.Fatalf("got error when unmount volume test for 1: %v", err)
+	}
+	_, err = os.Stat(path.Join(propagatedMountpoint, "test", "_mounts", "1"))
+	if !os.IsNotExist(err) {
+		t.Errorf("expected mount point of 1 removed, got %v", err)
+	}
+	_, err = os.Stat(path.Join(propagatedMountpoint, "test", "_mounts", "2"))
+	if err != nil {
+		t.Errorf("expected mount point of 2 kept, got %v", err)
+	}
+
+	// A mount point left behind by a crash is released by the next mount and by remove
+	err = os.MkdirAll(path.Join(propagatedMountpoint, "test", "_mounts", "crashed"), 0755)
+	if err != nil {
+		t.Fatalf("got error when create leftover mount point: %v", err)
+	}
+	_, err = driver.Mount("test", "crashed")
+	if err != nil {
+		t.Fatalf("got error when mount volume test over leftover mount point: %v", err)
+	}
+	for _, id := range []string{"2", "crashed"} {
+		err = driver.Unmount("test", id)
+		if err != nil {
+			t.Fatalf("got error when unmount volume test for %s: %v", id, err)
+		}
+	}
+	err = os.MkdirAll(path.Join(propagatedMountpoint, "test", "_mounts", "crashed"), 0755)
+	if err != nil {
+		t.Fatalf("got error when create leftover mount point: %v", err)
+	}
+	err = driver.Remove("test")
+	if err != nil {
+		t.Fatalf("got error when remove volume test with leftover mount point: %v", err)
+	}
+	_, err = os.Stat(path.Join(propagatedMountpoint, "test", "_mounts"))
+	if !os.IsNotExist(err) {
+		t.Errorf("expected leftover mount points released, got %v", err)
+	}
+
+	err = driver.Create("test/_mounts", nil)
+	if err == nil {
+		t.Errorf("expected error when create volume with reserved component _mounts")
+	}
+}
+
+func TestNFSDriverPaths(t *testing.T) {
+	propagatedMountpoint := t.TempDir()
+	driver, err := New(context.Background(), log.New("test-nfs").WithLogLevel(log.WarnLevel), "nfs", propagatedMountpoint, localNFSServerDriverOptions)
+	if err != nil {
+		t.Fatalf("got error when new nfs driver: %v", err)
+	}
+
+	err = driver.Create("test", nil)
+	if err != nil {
+		t.Fatalf("got error when create volume test: %v", err)
+	}
+	volumePath, err := driver.Path("test")
+	if err != nil || volumePath != path.Join(propagatedMountpoint, "test", "_data") {
+		t.Errorf("expected absolute path %s, got %s, %v", path.Join(propagatedMountpoint, "test", "_data"), volumePath, err)
+	}
+
+	// Missing volumes and reserved names fail without a path
+	for _, name := range []string{"non-exist", "metadata.db", "a/b"} {
+		volumePath, err := driver.Path(name)
+		if err == nil || volumePath != "" {
+			t.Errorf("expected error without path for path of volume %s, got %s, %v", name, volumePath, err)
+		}
+		mountpoint, err := driver.Mount(name, "1")
+		if err == nil || mountpoint != "" {
+			t.Errorf("expected error without path for mount of volume %s, got %s, %v", name, mountpoint, err)
+		}
+	}
+	_, err = driver.Path("non-exist")
+	if !errors.Is(err, apis.ErrVolumeNotFound) {
+		t.Errorf("expected volume not found error, got %v", err)
+	}
+
+	// The paths stay the same once the plugin restarted
+	err = driver.Destroy()
+	if err != nil {
+		t.Fatalf("got error when destroy nfs driver: %v", err)
+	}
+	driver, err = New(context.Background(), log.New("test-nfs").WithLogLevel(log.WarnLevel), "nfs", propagatedMountpoint, localNFSServerDriverOptions)
+	if err != nil {
+		t.Fatalf("got error when new nfs driver again: %v", err)
+	}
+	defer driver.Destroy()
+
+	restartedPath, err := driver.Path("test")
+	if err != nil || restartedPath != volumePath {
+		t.Errorf("expected path %s after restart, got %s, %v", volumePath, restartedPath, err)
+	}
+	mountpoint, err := driver.Mount("test", "1")
+	if err != nil || mountpoint != path.Join(propagatedMountpoint, "test", "_mounts", "1") {
+		t.Errorf("expected absolute mountpoint after restart, got %s, %v", mountpoint, err)
+	}
+}
+
+func TestNFSDriverClone(t *testing.T) {
+	driver, propagatedMountpoint := newTestNFSDriver(t, localNFSServerDriverOptions)
+
+	err := driver.Create("source", nil)
+	if err != nil {
+		t.Fatalf("got error when create volume source: %v", err)
+	}
+	err = os.MkdirAll(path.Join(propagatedMountpoint, "source", "_data", "dir"), 0755)
+	if err != nil {
+		t.Fatalf("got error when create source data: %v", err)
+	}
+	err = os.WriteFile(path.Join(propagatedMountpoint, "source", "_data", "dir", "file"), []byte("data"), 0644)
+	if err != nil {
+		t.Fatalf("got error when write source data: %v", err)
+	}
+	_, err = driver.Mount("source", "1")
+	if err != nil {
+		t.Fatalf("got error when mount volume source: %v", err)
+	}
+
+	for _, options := range []map[string]string{
+		{"from": "non-exist"},
+		{"from": "source"},
+		{"from": "source", "importPath": "dir"},
+	} {
+		err = driver.Create("clone", options)
+		if err == nil {
+			t.Errorf("expected error when create volume clone with %v", options)
+		}
+	}
+
+	err = driver.Create("clone", map[string]string{"from": "source", "allowLiveClone": "true"})
+	if err != nil {
+		t.Fatalf("got error when create volume clone: %v", err)
+	}
+	var volumeMetadata *apis.VolumeMetadata
+	for i := 0; i < 100; i++ {
+		volumeMetadata, err = driver.Get("clone")
+		if err != nil {
+			t.Fatalf("got error when get volume clone: %v", err)
+		}
+		if volumeMetadata.Status.Clone.State != cloneRunning {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if volumeMetadata.Status.Clone.State != cloneCompleted || volumeMetadata.Status.Clone.Source != "source" {
+		t.Fatalf("expected clone from source completed, got %+v", volumeMetadata.Status.Clone)
+	}
+	content, err := os.ReadFile(path.Join(propagatedMountpoint, "clone", "_data", "dir", "file"))
+	if err != nil || string(content) != "data" {
+		t.Errorf("expected cloned data, got %q, %v", string(content), err)
+	}
+	_, err = driver.Mount("clone", "1")
+	if err != nil {
+		t.Errorf("got error when mount volume clone: %v", err)
+	}
+}
+
+func TestNFSDriverCloneInterrupted(t *testing.T) {
+	propagatedMountpoint := t.TempDir()
+	newNodeDriver := func(nodeID string) apis.Driver {
+		driver, err := New(context.Background(), log.New("test-nfs").WithLogLevel(log.ErrorLevel), "nfs", propagatedMountpoint, fmt.Sprintf(`{
+			"address": "nfs-server.mock",
+			"remotePath": "/mock",
+			"usageTTL": "1h",
+			"nodeID": %q
+		}`, nodeID))
+		if err != nil {
+			t.Fatalf("got error when new nfs driver on node %s: %v", nodeID, err)
+		}
+		return driver
+	}
+	getClone := func(driver apis.Driver, name string) *apis.CloneStatus {
+		volumeMetadata, err := driver.Get(name)
+		if err != nil {
+			t.Fatalf("got error when get volume %s: %v", name, err)
+		}
+		return volumeMetadata.Status.Clone
+	}
+
+	driver := newNodeDriver("node-a")
+	err := driver.Create("source", nil)
+	if err != nil {
+		t.Fatalf("got error when create volume source: %v", err)
+	}
+	for i := 0; i < 500; i++ {
+		err = os.WriteFile(path.Join(propagatedMountpoint, "source", "_data", fmt.Sprintf("file-%d", i)), []byte("data"), 0644)
+		if err != nil {
+			t.Fatalf("got error when write source data: %v", err)
+		}
+	}
+
+	// A shutdown records the result of the clone, which never stays running
+	err = driver.Create("stopped", map[string]string{"from": "source"})
+	if err != nil {
+		t.Fatalf("got error when create volume stopped: %v", err)
+	}
+	err = driver.Destroy()
+	if err != nil {
+		t.Fatalf("got error when destroy nfs driver: %v", err)
+	}
+	driver = newNodeDriver("node-b")
+	if clone := getClone(driver, "stopped"); clone.State == cloneRunning || clone.State == cloneFailed && clone.Error != cloneShutdownError {
+		t.Errorf("expected clone stopped by shutdown recorded, got %+v", clone)
+	}
+
+	// A crash leaves the clone running, until its node starts again
+	err = driver.(*nfs).db.CreateVolumeMetadata("crashed", func(volumeMetadata *apis.VolumeMetadata) error {
+		*volumeMetadata = apis.VolumeMetadata{
+			Mountpoint: path.Join("crashed", "_data"),
+			Spec:       &apis.VolumeSpec{},
+			Status:     &apis.VolumeStatus{Clone: &apis.CloneStatus{Source: "source", State: cloneRunning, Node: "node-a"}},
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("got error when create metadata of volume crashed: %v", err)
+	}
+	err = driver.Destroy()
+	if err != nil {
+		t.Fatalf("got error when destroy nfs driver: %v", err)
+	}
+	driver = newNodeDriver("node-b")
+	if clone := getClone(driver, "crashed"); clone.State != cloneRunning {
+		t.Errorf("expected clone of node-a kept by node-b, got %+v", clone)
+	}
+	err = driver.Destroy()
+	if err != nil {
+		t.Fatalf("got error when destroy nfs driver: %v", err)
+	}
+	driver = newNodeDriver("node-a")
+	defer func() {
+		if err := driver.Destroy(); err != nil {
+			t.Errorf("got error when destroy nfs driver: %v", err)
+		}
+	}()
+	if clone := getClone(driver, "crashed"); clone.State != cloneFailed || clone.Error != cloneRestartError {
+		t.Errorf("expected clone interrupted by restart failed, got %+v", clone)
+	}
+	err = driver.Remove("crashed")
+	if err != nil {
+		t.Errorf("got error when remove volume with failed clone: %v", err)
+	}
+}
+
+func TestNFSDriverImport(t *testing.T) {
+	driver, propagatedMountpoint := newTestNFSDriver(t, `{
+		"address": "nfs-server.mock",
+		"remotePath": "/mock",
+		"purgeAfterDelete": true
+	}`)
+
+	for _, dir := range []string{"datasets/prod", "datasets/moved"} {
+		err := os.MkdirAll(path.Join(propagatedMountpoint, dir), 0755)
+		if err != nil {
+			t.Fatalf("got error when create directory %s: %v", dir, err)
+		}
+		err = os.WriteFile(path.Join(propagatedMountpoint, dir, "file"), []byte("data"), 0644)
+		if err != nil {
+			t.Fatalf("got error when write file in %s: %v", dir, err)
+		}
+	}
+
+	err := driver.Create("imported", map[string]string{"importPath": "datasets/prod"})
+	if err != nil {
+		t.Fatalf("got error when create volume imported: %v", err)
+	}
+	content, err := os.ReadFile(path.Join(propagatedMountpoint, "imported", "_data", "file"))
+	if err != nil || string(content) != "data" {
+		t.Errorf("expected imported data, got %q, %v", string(content), err)
+	}
+	_, err = driver.Mount("imported", "1")
+	if err != nil {
+		t.Fatalf("got error when mount volume imported: %v", err)
+	}
+
+	for _, importPath := range []string{"metadata.db", "../escape", "/etc", "missing", "imported", "datasets/prod/file"} {
+		err = driver.Create("invalid", map[string]string{"importPath": importPath})
+		if err == nil {
+			t.Errorf("expected error when import %s", importPath)
+		}
+	}
+
+	// Without move the original directory is kept, even when the volume is purged
+	err = driver.Unmount("imported", "1")
+	if err != nil {
+		t.Fatalf("got error when unmount volume imported: %v", err)
+	}
+	err = driver.Remove("imported")
+	if err != nil {
+		t.Fatalf("got error when remove volume imported: %v", err)
+	}
+	_, err = os.Stat(path.Join(propagatedMountpoint, "datasets", "prod", "file"))
+	if err != nil {
+		t.Errorf("expected original directory kept, got %v", err)
+	}
+
+	err = driver.Create("moved", map[string]string{"importPath": "datasets/moved", "move": "true"})
+	if err != nil {
+		t.Fatalf("got error when create volume moved: %v", err)
+	}
+	_, err = os.Stat(path.Join(propagatedMountpoint, "datasets", "moved"))
+	if !os.IsNotExist(err) {
+		t.Errorf("expected original directory moved, got %v", err)
+	}
+	info, err := os.Lstat(path.Join(propagatedMountpoint, "moved", "_data"))
+	if err != nil || !info.IsDir() {
+		t.Errorf("expected moved directory as volume data, got %v", err)
+	}
+}
+
+func TestNFSDriverOrphans(t *testing.T) {
+	driver, propagatedMountpoint := newTestNFSDriver(t, `{
+		"address": "nfs-server.mock",
+		"remotePath": "/mock"
+	}`)
+
+	// A crash after the directory was created and before the metadata was written leaves an orphan
+	err := os.MkdirAll(path.Join(propagatedMountpoint, "crashed", "_data"), 0755)
+	if err != nil {
+		t.Fatalf("got error when create orphan directory: %v", err)
+	}
+	modTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	err = os.Chtimes(path.Join(propagatedMountpoint, "crashed"), modTime, modTime)
+	if err != nil {
+		t.Fatalf("got error when set orphan directory mtime: %v", err)
+	}
+	err = os.MkdirAll(path.Join(propagatedMountpoint, "datasets", "prod"), 0755)
+	if err != nil {
+		t.Fatalf("got error when create directory datasets: %v", err)
+	}
+
+	err = driver.Create("crashed", nil)
+	if err == nil || !strings.Contains(err.Error(), "adoptExisting") {
+		t.Errorf("expected error pointing to adoptExisting when create volume over orphan directory, got %v", err)
+	}
+
+	// Data kept by a removal without purge is not an orphan, neither listed nor adopted
+	for _, name := range []string{"kept", "gone"} {
+		err = driver.Create(name, nil)
+		if err != nil {
+			t.Fatalf("got error when create volume %s: %v", name, err)
+		}
+		err = os.WriteFile(path.Join(propagatedMountpoint, name, "_data", "file"), []byte(name), 0644)
+		if err != nil {
+			t.Fatalf("got error when write data of volume %s: %v", name, err)
+		}
+		err = driver.Remove(name)
+		if err != nil {
+			t.Fatalf("got error when remove volume %s: %v", name, err)
+		}
+	}
+
+	volumeMetadataMap, err := driver.List()
+	if err != nil {
+		t.Fatalf("got error when list volumes: %v", err)
+	}
+	if len(volumeMetadataMap) != 0 {
+		t.Errorf("expected no volumes without adoptOrphans, got %d", len(volumeMetadataMap))
+	}
+
+	err = driver.Create("adopted", map[string]string{"adoptExisting": "true", "from": "kept"})
+	if err == nil {
+		t.Errorf("expected error when combine adoptExisting and from")
+	}
+
+	err = driver.Destroy()
+	if err != nil {
+		t.Fatalf("got error when destroy nfs driver: %v", err)
+	}
+	driver, err = New(context.Background(), log.New("test-nfs").WithLogLevel(log.WarnLevel), "nfs", propagatedMountpoint, `{
+		"address": "nfs-server.mock",
+		"remotePath": "/mock",
+		"adoptOrphans": true
+	}`)
+	if err != nil {
+		t.Fatalf("got error when new nfs driver: %v", err)
+	}
+	defer func() {
+		if err := driver.Destroy(); err != nil {
+			t.Errorf("got error when destroy nfs driver: %v", err)
+		}
+	}()
+
+	volumeMetadataMap, err = driver.List()
+	if err != nil {
+		t.Fatalf("got error when list volumes: %v", err)
+	}
+	if len(volumeMetadataMap) != 1 || volumeMetadataMap["crashed"] == nil {
+		t.Fatalf("expected volume crashed only, got %v", slices.Collect(maps.Keys(volumeMetadataMap)))
+	}
+	if !volumeMetadataMap["crashed"].CreatedAt.Equal(modTime) {
+		t.Errorf("expected adopted volume created at %s, got %s", modTime, volumeMetadataMap["crashed"].CreatedAt)
+	}
+
+	mountpoint, err := driver.Mount("crashed", "1")
+	if err != nil || mountpoint != path.Join(propagatedMountpoint, "crashed", "_mounts", "1") {
+		t.Errorf("expected adopted volume mounted, got %s, %v", mountpoint, err)
+	}
+}
+
+func TestNFSDriverNestedOrphans(t *testing.T) {
+	driver, propagatedMountpoint := newTestNFSDriver(t, `{
+		"address": "nfs-server.mock",
+		"remotePath": "/mock",
+		"allowNestedNames": true,
+		"adoptOrphans": true
+	}`)
+
+	err := driver.Create("team/existing", nil)
+	if err != nil {
+		t.Fatalf("got error when create volume: %v", err)
+	}
+
+	// Orphans are looked for next to existing volumes, other directory trees of the share are not walked
+	for _, dir := range []string{"team/crashed/_data", "datasets/prod/_data", "team/sources/raw/_data"} {
+		err = os.MkdirAll(path.Join(propagatedMountpoint, dir), 0755)
+		if err != nil {
+			t.Fatalf("got error when create directory %s: %v", dir, err)
+		}
+	}
+	err = driver.Create("team/imported", map[string]string{"importPath": "team/sources"})
+	if err != nil {
+		t.Fatalf("got error when import volume: %v", err)
+	}
+
+	volumeMetadataMap, err := driver.List()
+	if err != nil {
+		t.Fatalf("got error when list volumes: %v", err)
+	}
+	names := slices.Sorted(maps.Keys(volumeMetadataMap))
+	if !slices.Equal(names, []string{"team/crashed", "team/existing", "team/imported"}) {
+		t.Errorf("expected orphan team/crashed adopted only, got %v", names)
+	}
+}
+
+func TestNFSDriverRemoveAndCreate(t *testing.T) {
+	driver, propagatedMountpoint := newTestNFSDriver(t, localNFSServerDriverOptions)
+
+	err := driver.Create("recreated", nil)
+	if err != nil {
+		t.Fatalf("got error when create volume: %v", err)
+	}
+	err = os.WriteFile(path.Join(propagatedMountpoint, "recreated", "_data", "file"), []byte("kept"), 0644)
+	if err != nil {
+		t.Fatalf("got error when write volume data: %v", err)
+	}
+	err = driver.Remove("recreated")
+	if err != nil {
+		t.Fatalf("got error when remove volume: %v", err)
+	}
+
+	// Docker creates the volume again on the next run, which reuses the data kept without purge
+	err = driver.Create("recreated", nil)
+	if err != nil {
+		t.Fatalf("got error when create removed volume again: %v", err)
+	}
+	content, err := os.ReadFile(path.Join(propagatedMountpoint, "recreated", "_data", "file"))
+	if err != nil || string(content) != "kept" {
+		t.Errorf("expected kept data reused, got %q, %v", content, err)
+	}
+	_, err = os.Stat(path.Join(propagatedMountpoint, "recreated", removedMarker))
+	if !os.IsNotExist(err) {
+		t.Errorf("expected removed mark cleared on create, got %v", err)
+	}
+
+	err = driver.Remove("recreated")
+	if err != nil {
+		t.Fatalf("got error when remove recreated volume: %v", err)
+	}
+	err = driver.Create("recreated", map[string]string{"adoptExisting": "true"})
+	if err != nil {
+		t.Errorf("got error when create removed volume with adoptExisting: %v", err)
+	}
+}
+
+func TestNFSDriverJSONFileStore(t *testing.T) {
+	propagatedMountpoint := t.TempDir()
+	newDriver := func(metadataStore string) apis.Driver {
+		driver, err := New(context.Background(), log.New("test-nfs").WithLogLevel(log.WarnLevel), "nfs", propagatedMountpoint, fmt.Sprintf(`{
+			"address": "nfs-server.mock",
+			"remotePath": "/mock",
+			"metadataStore": %q
+		}`, metadataStore))
+		if err != nil {
+			t.Fatalf("got error when new nfs driver with %s metadata store: %v", metadataStore, err)
+		}
+		return driver
+	}
+
+	driver := newDriver("badger")
+	err := driver.Create("legacy", nil)
+	if err != nil {
+		t.Fatalf("got error when create volume legacy: %v", err)
+	}
+	_, err = driver.Mount("legacy", "1")
+	if err != nil {
+		t.Fatalf("got error when mount volume legacy: %v", err)
+	}
+	err = driver.Destroy()
+	if err != nil {
+		t.Fatalf("got error when destroy nfs driver: %v", err)
+	}
+
+	// The badger metadata is imported once and left in place
+	driver = newDriver("jsonfile")
+	volumeMetadata, err := driver.Get("legacy")
+	if err != nil {
+		t.Fatalf("got error when get imported volume legacy: %v", err)
+	}
+	if !slices.Equal(volumeMetadata.Status.MountBy, []string{"1"}) {
+		t.Errorf("expected imported volume mounted by 1, got %v", volumeMetadata.Status.MountBy)
+	}
+	history, err := driver.History("legacy")
+	if err != nil || len(history) != 2 {
+		t.Errorf("expected 2 imported history entries, got %d, %v", len(history), err)
+	}
+	_, err = os.Stat(path.Join(propagatedMountpoint, "metadata.db"))
+	if err != nil {
+		t.Errorf("expected badger metadata kept, got %v", err)
+	}
+
+	// The action of a write still decides whether it is applied
+	err = driver.Remove("legacy")
+	if err == nil {
+		t.Errorf("expected error when remove mounted volume legacy")
+	}
+	err = driver.Create("json", nil)
+	if err != nil {
+		t.Fatalf("got error when create volume json: %v", err)
+	}
+	err = driver.Create("json", nil)
+	if err == nil {
+		t.Errorf("expected error when create volume json twice")
+	}
+	err = driver.Create("metadata.json", nil)
+	if err == nil {
+		t.Errorf("expected error when create volume with reserved name metadata.json")
+	}
+
+	content, err := os.ReadFile(path.Join(propagatedMountpoint, "metadata.json"))
+	if err != nil {
+		t.Fatalf("got error when read metadata file: %v", err)
+	}
+	document := struct {
+		Volumes map[string]*apis.VolumeMetadata `json:"volumes"`
+	}{}
+	err = json.Unmarshal(content, &document)
+	if err != nil {
+		t.Fatalf("got error when parse metadata file: %v", err)
+	}
+	if document.Volumes["legacy"] == nil || document.Volumes["json"] == nil {
+		t.Errorf("expected volumes legacy and json in metadata file, got %s", string(content))
+	}
+	err = driver.Destroy()
+	if err != nil {
+		t.Fatalf("got error when destroy nfs driver: %v", err)
+	}
+
+	// Later starts keep the JSON metadata instead of importing again
+	driver = newDriver("jsonfile")
+	defer func() {
+		if err := driver.Destroy(); err != nil {
+			t.Errorf("got error when destroy nfs driver: %v", err)
+		}
+	}()
+	volumeMetadataMap, err := driver.List()
+	if err != nil {
+		t.Fatalf("got error when list volumes: %v", err)
+	}
+	if len(volumeMetadataMap) != 2 {
+		t.Errorf("expected 2 volumes, got %d", len(volumeMetadataMap))
+	}
+
+	for _, driverOptions := range []string{
+		`{"address": "nfs-server.mock", "remotePath": "/mock", "metadataStore": "sqlite"}`,
+		`{"address": "nfs-server.mock", "remotePath": "/mock", "metadataStore": "jsonfile", "metadataReplicaPath": "/tmp/replica"}`,
+	} {
+		_, err = New(context.Background(), log.New("test-nfs").WithLogLevel(log.WarnLevel), "nfs", t.TempDir(), driverOptions)
+		if err == nil {
+			t.Errorf("expected error for driver options %s", driverOptions)
+		}
+	}
+}
+
+func TestNFSDriverVolumeLocking(t *testing.T) {
+	driver, _ := newTestNFSDriver(t, `{
+		"address": "nfs-server.mock",
+		"remotePath": "/mock",
+		"purgeAfterDelete": true,
+		"historyDepth": 0,
+		"usageTTL": "1h"
+	}`)
+
+	// A create stuck on its volume only blocks the operations on that volume
+	release := driver.(*nfs).lockVolume("slow", false)
+	created := make(chan error, 1)
+	go func() {
+		created <- driver.Create("slow", nil)
+	}()
+
+	done := make(chan error, 1)
+	go func() {
+		err := driver.Create("fast", nil)
+		if err == nil {
+			_, err = driver.Mount("fast", "1")
+		}
+		if err == nil {
+			err = driver.Unmount("fast", "1")
+		}
+		if err == nil {
+			_, err = driver.List()
+		}
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("got error from operations on volume fast: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("expected operations on volume fast not to wait for volume slow")
+	}
+
+	select {
+	case err := <-created:
+		t.Fatalf("expected create of volume slow to wait for its lock, got %v", err)
+	default:
+	}
+	release()
+	err := <-created
+	if err != nil {
+		t.Fatalf("got error when create volume slow: %v", err)
+	}
+
+	err = driver.Create("shared", nil)
+	if err != nil {
+		t.Fatalf("got error when create volume shared: %v", err)
+	}
+
+	// Creates, mounts and removes racing on the same names must neither deadlock nor lose a mount.
+	// Every metadata access reopens the store and gets slower as it grows, so the load is kept small.
+	const workers = 4
+	const iterations = 4
+	names := []string{"race-0", "race-1", "race-2"}
+	errs := make(chan error, workers*iterations*2)
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+
+		wg := sync.WaitGroup{}
+		for i := 0; i < workers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				for j := 0; j < iterations; j++ {
+					name := names[(i+j)%len(names)]
+					id := fmt.Sprintf("%d-%d", i, j)
+
+					// Races with a remove or another create are expected to fail, but never to leave a mount behind
+					_ = driver.Create(name, nil)
+					if _, err := driver.Mount(name, id); err == nil {
+						if err := driver.Unmount(name, id); err != nil {
+							errs <- fmt.Errorf("got error when unmount volume %s from %s: %v", name, id, err)
+						}
+					}
+					_ = driver.Remove(name)
+
+					if _, err := driver.Mount("shared", id); err != nil {
+						errs <- fmt.Errorf("got error when mount volume shared for %s: %v", id, err)
+					}
+				}
+			}()
+		}
+		wg.Wait()
+	}()
+
+	select {
+	case <-finished:
+	case <-time.After(2 * time.Minute):
+		t.Fatalf("expected concurrent operations to finish, they deadlocked")
+	}
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	volumeMetadataMap, err := driver.List()
+	if err != nil {
+		t.Fatalf("got error when list volumes: %v", err)
+	}
+	for name, volumeMetadata := range volumeMetadataMap {
+		if name != "shared" && len(volumeMetadata.Status.MountBy) != 0 {
+			t.Errorf("expected volume %s unmounted, got mounted by %v", name, volumeMetadata.Status.MountBy)
+		}
+	}
+	if mountBy := volumeMetadataMap["shared"].Status.MountBy; len(mountBy) != workers*iterations {
+		t.Errorf("expected volume shared mounted by %d ids, got %d", workers*iterations, len(mountBy))
+	}
+}
+
+func TestNFSDriverMountOwnership(t *testing.T) {
+	propagatedMountpoint := t.TempDir()
+	newNodeDriver := func(nodeID string) apis.Driver {
+		driver, err := New(context.Background(), log.New("test-nfs").WithLogLevel(log.ErrorLevel), "nfs", propagatedMountpoint, fmt.Sprintf(`{
+			"address": "nfs-server.mock",
+			"remotePath": "/mock",
+			"historyDepth": 0,
+			"usageTTL": "1h",
+			"nodeID": %q,
+			"claimTTL": "1m"
+		}`, nodeID))
+		if err != nil {
+			t.Fatalf("got error when new nfs driver on node %s: %v", nodeID, err)
+		}
+		t.Cleanup(func() {
+			if err := driver.Destroy(); err != nil {
+				t.Errorf("got error when destroy nfs driver on node %s: %v", nodeID, err)
+			}
+		})
+		return driver
+	}
+	// expireLease makes node look like it crashed a while ago
+	expireLease := func(nodeID string) {
+		err := os.WriteFile(path.Join(propagatedMountpoint, nodeLeasesPath, nodeID), []byte(time.Now().Add(-time.Hour).Format(time.RFC3339Nano)), 0644)
+		if err != nil {
+			t.Fatalf("got error when expire lease of node %s: %v", nodeID, err)
+		}
+	}
+
+	nodeA := newNodeDriver("node-a")
+	nodeB := newNodeDriver("node-b")
+	for _, name := range []string{"shared", "forced", "crashed"} {
+		err := nodeA.Create(name, nil)
+		if err != nil {
+			t.Fatalf("got error when create volume %s: %v", name, err)
+		}
+		_, err = nodeA.Mount(name, "a1")
+		if err != nil {
+			t.Fatalf("got error when mount volume %s on node-a: %v", name, err)
+		}
+	}
+
+	// Both nodes share the metadata, so every mount made concurrently on either of them is kept
+	const mounts = 4
+	errs := make(chan error, 2*mounts)
+	wg := sync.WaitGroup{}
+	for i := 0; i < mounts; i++ {
+		for node, driver := range map[string]apis.Driver{"a": nodeA, "b": nodeB} {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, err := driver.Mount("shared", fmt.Sprintf("%s-%d", node, i)); err != nil {
+					errs <- err
+				}
+			}()
+		}
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("got error from concurrent mount: %v", err)
+	}
+
+	volumeMetadata, err := nodeB.Get("shared")
+	if err != nil {
+		t.Fatalf("got error when get volume shared: %v", err)
+	}
+	if len(volumeMetadata.Status.MountBy) != 2*mounts+1 || volumeMetadata.Status.MountNodes["a1"] != "node-a" || volumeMetadata.Status.MountNodes["b-0"] != "node-b" {
+		t.Errorf("expected %d mounts owned by their nodes, got %v %v", 2*mounts+1, volumeMetadata.Status.MountBy, volumeMetadata.Status.MountNodes)
+	}
+
+	// A live node keeps its mounts
+	err = nodeB.Unmount("shared", "a1")
+	if err == nil || !strings.Contains(err.Error(), "node-a") {
+		t.Errorf("expected error naming node-a when unmount its mount from node-b, got %v", err)
+	}
+	_, err = nodeB.Mount("shared", "a1")
+	if err == nil {
+		t.Errorf("expected error when take over the mount of live node-a")
+	}
+	err = nodeB.Remove("crashed")
+	if err == nil || !strings.Contains(err.Error(), "a1 on node node-a") {
+		t.Errorf("expected error naming node-a when remove volume crashed, got %v", err)
+	}
+
+	// Force removes a volume whose node is still alive
+	err = nodeB.(apis.ForceRemover).ForceRemove("forced")
+	if err != nil {
+		t.Fatalf("got error when force remove volume forced: %v", err)
+	}
+
+	// Once its lease expired, the mounts of a crashed node are taken over and dropped
+	err = nodeA.Destroy()
+	if err != nil {
+		t.Fatalf("got error when destroy nfs driver on node-a: %v", err)
+	}
+	expireLease("node-a")
+
+	_, err = nodeB.Mount("shared", "a1")
+	if err != nil {
+		t.Fatalf("got error when take over mount a1 of crashed node-a: %v", err)
+	}
+	volumeMetadata, err = nodeB.Get("shared")
+	if err != nil || volumeMetadata.Status.MountNodes["a1"] != "node-b" {
+		t.Errorf("expected mount a1 owned by node-b, got %v, %v", volumeMetadata.Status.MountNodes, err)
+	}
+	err = nodeB.Unmount("shared", "a1")
+	if err != nil {
+		t.Errorf("got error when unmount taken over mount a1: %v", err)
+	}
+
+	err = nodeB.Remove("crashed")
+	if err != nil {
+		t.Errorf("got error when remove volume crashed of crashed node-a: %v", err)
+	}
+	_, err = os.Stat(path.Join(propagatedMountpoint, "crashed"))
+	if err != nil {
+		t.Errorf("expected data of volume crashed kept without purgeAfterDelete, got %v", err)
+	}
+
+	// Leases are not volumes
+	err = nodeB.Create(nodeLeasesPath, nil)
+	if err == nil {
+		t.Errorf("expected error when create volume with reserved name %s", nodeLeasesPath)
+	}
+	if nodeB.Status()["nodeID"] != "node-b" {
+		t.Errorf("expected nodeID node-b in status, got %v", nodeB.Status()["nodeID"])
+	}
+
+	for _, driverOptions := range []string{
+		`{"address": "nfs-server.mock", "remotePath": "/mock", "nodeID": "node/a"}`,
+		`{"address": "nfs-server.mock", "remotePath": "/mock", "claimTTL": "soon"}`,
+	} {
+		_, err = New(context.Background(), log.New("test-nfs").WithLogLevel(log.WarnLevel), "nfs", t.TempDir(), driverOptions)
+		if err == nil {
+			t.Errorf("expected error for driver options %s", driverOptions)
+		}
+	}
+}
+
+func TestNFSDriverMetrics(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("got error when pick metrics address: %v", err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+
+	// The gauges are counted from the metadata, an orphan is neither counted nor adopted by their refresh
+	propagatedMountpoint := t.TempDir()
+	err = os.MkdirAll(path.Join(propagatedMountpoint, "orphan", "_data"), 0755)
+	if err != nil {
+		t.Fatalf("got error when create orphan directory: %v", err)
+	}
+
+	driver, err := New(context.Background(), log.New("test-nfs").WithLogLevel(log.WarnLevel), "nfs", propagatedMountpoint, fmt.Sprintf(`{
+		"address": "nfs-server.mock",
+		"remotePath": "/mock",
+		"historyDepth": 0,
+		"adoptOrphans": true,
+		"metricsAddress": %q,
+		"metricsRefreshInterval": "50ms"
+	}`, address))
+	if err != nil {
+		t.Fatalf("got error when new nfs driver: %v", err)
+	}
+	destroyed := false
+	t.Cleanup(func() {
+		if destroyed {
+			return
+		}
+		if err := driver.Destroy(); err != nil {
+			t.Errorf("got error when destroy nfs driver: %v", err)
+		}
+	})
+
+	if _, err := New(context.Background(), log.New("test-nfs").WithLogLevel(log.ErrorLevel), "nfs", t.TempDir(), fmt.Sprintf(`{
+		"address": "nfs-server.mock",
+		"remotePath": "/mock",
+		"metricsAddress": %q
+	}`, address)); err == nil {
+		t.Errorf("expect error when metricsAddress is already in use")
+	}
+
+	for _, name := range []string{"metrics-1", "metrics-2"} {
+		if err := driver.Create(name, nil); err != nil {
+			t.Fatalf("got error when create volume %s: %v", name, err)
+		}
+	}
+	if _, err := driver.Mount("metrics-1", "1"); err != nil {
+		t.Fatalf("got error when mount volume: %v", err)
+	}
+	if _, err := driver.Get("missing"); err == nil {
+		t.Fatalf("expect error when get missing volume")
+	}
+
+	scrape := func() string {
+		resp, err := http.Get("http://" + address + "/metrics")
+		if err != nil {
+			t.Fatalf("got error when scrape metrics: %v", err)
+		}
+		defer resp.Body.Close()
+		body := &strings.Builder{}
+		if _, err := io.Copy(body, resp.Body); err != nil {
+			t.Fatalf("got error when read metrics: %v", err)
+		}
+		return body.String()
+	}
+
+	expected := []string{
+		`nfs_driver_operations_total{driver="nfs",operation="create",result="success"} 2`,
+		`nfs_driver_operations_total{driver="nfs",operation="get",result="failure"} 1`,
+		`nfs_driver_operation_duration_seconds_count{driver="nfs",operation="mount",result="success"} 1`,
+		`nfs_driver_volumes{driver="nfs"} 2`,
+		`nfs_driver_mounted_volumes{driver="nfs"} 1`,
+	}
+	metrics := ""
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+		metrics = scrape()
+		if !slices.ContainsFunc(expected, func(line string) bool { return !strings.Contains(metrics, line) }) {
+			break
+		}
+	}
+	for _, line := range expected {
+		if !strings.Contains(metrics, line) {
+			t.Errorf("expect metrics to contain %s, got:\n%s", line, metrics)
+		}
+	}
+	if strings.Contains(metrics, `operation="list"`) {
+		t.Errorf("expect refresh of the gauges not counted as list operations, got:\n%s", metrics)
+	}
+
+	destroyed = true
+	if err := driver.Destroy(); err != nil {
+		t.Fatalf("got error when destroy nfs driver: %v", err)
+	}
+	if resp, err := http.Get("http://" + address + "/metrics"); err == nil {
+		resp.Body.Close()
+		t.Errorf("expect metrics server to be shut down after destroy")
+	}
+}
+
+func TestNFSDriverMissingLease(t *testing.T) {
+	propagatedMountpoint := t.TempDir()
+	driverOptions := func(nodeID string) string {
+		return fmt.Sprintf(`{
+			"address": "nfs-server.mock",
+			"remotePath": "/mock",
+			"historyDepth": 0,
+			"usageTTL": "1h",
+			"nodeID": %q,
+			"claimTTL": "1m"
+		}`, nodeID)
+	}
+
+	// A node that can not write its lease does not start, as its mounts could be taken over
+	err := os.WriteFile(path.Join(propagatedMountpoint, nodeLeasesPath), nil, 0644)
+	if err != nil {
+		t.Fatalf("got error when block node leases: %v", err)
+	}
+	_, err = New(context.Background(), log.New("test-nfs").WithLogLevel(log.ErrorLevel), "nfs", propagatedMountpoint, driverOptions("node-a"))
+	if err == nil || !strings.Contains(err.Error(), "lease") {
+		t.Errorf("expected error about the lease when new nfs driver, got %v", err)
+	}
+	err = os.Remove(path.Join(propagatedMountpoint, nodeLeasesPath))
+	if err != nil {
+		t.Fatalf("got error when unblock node leases: %v", err)
+	}
+
+	nodeB, err := New(context.Background(), log.New("test-nfs").WithLogLevel(log.ErrorLevel), "nfs", propagatedMountpoint, driverOptions("node-b"))
+	if err != nil {
+		t.Fatalf("got error when new nfs driver on node-b: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := nodeB.Destroy(); err != nil {
+			t.Errorf("got error when destroy nfs driver on node-b: %v", err)
+		}
+	})
+
+	// node-a claims a mount but its lease is not there yet, as on a node still starting
+	err = nodeB.Create("recent", nil)
+	if err != nil {
+		t.Fatalf("got error when create volume recent: %v", err)
+	}
+	claim := func(claimedAt time.Time) {
+		err := nodeB.(*nfs).db.SetVolumeMetadata("recent", func(volumeMetadata *apis.VolumeMetadata) error {
+			volumeMetadata.Status.MountBy = []string{"a1"}
+			volumeMetadata.Status.MountNodes = map[string]string{"a1": "node-a"}
+			volumeMetadata.Status.MountClaimedAt = map[string]time.Time{"a1": claimedAt}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("got error when claim mount a1 for node-a: %v", err)
+		}
+	}
+
+	claim(time.Now())
+	_, err = nodeB.Mount("recent", "a1")
+	if err == nil {
+		t.Errorf("expected error when take over a recent mount of node-a without lease")
+	}
+	err = nodeB.Remove("recent")
+	if err == nil || !strings.Contains(err.Error(), "a1 on node node-a") {
+		t.Errorf("expected error naming node-a when remove volume recent, got %v", err)
+	}
+
+	// Once claimTTL passed since the claim without any lease, the mount is stale
+	claim(time.Now().Add(-time.Hour))
+	err = nodeB.Remove("recent")
+	if err != nil {
+		t.Errorf("got error when remove volume with a stale mount of node-a: %v", err)
+	}
+}
+
+func TestNFSDriverLegacyClaims(t *testing.T) {
+	propagatedMountpoint := t.TempDir()
+	driverOptions := `{
+		"address": "nfs-server.mock",
+		"remotePath": "/mock",
+		"historyDepth": 0,
+		"usageTTL": "1h",
+		"nodeID": "node-a",
+		"claimTTL": "1m"
+	}`
+
+	// A mount recorded by an earlier version has neither a node nor a claim time
+	driver, err := New(context.Background(), log.New("test-nfs").WithLogLevel(log.ErrorLevel), "nfs", propagatedMountpoint, driverOptions)
+	if err != nil {
+		t.Fatalf("got error when new nfs driver: %v", err)
+	}
+	err = driver.Create("legacy", nil)
+	if err != nil {
+		t.Fatalf("got error when create volume legacy: %v", err)
+	}
+	err = driver.(*nfs).db.SetVolumeMetadata("legacy", func(volumeMetadata *apis.VolumeMetadata) error {
+		volumeMetadata.Status.MountBy = []string{"old"}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("got error when claim mount old without a node: %v", err)
+	}
+	err = driver.Destroy()
+	if err != nil {
+		t.Fatalf("got error when destroy nfs driver: %v", err)
+	}
+
+	// The upgraded driver starts the claimTTL of the mount
+	driver, err = New(context.Background(), log.New("test-nfs").WithLogLevel(log.ErrorLevel), "nfs", propagatedMountpoint, driverOptions)
+	if err != nil {
+		t.Fatalf("got error when new upgraded nfs driver: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := driver.Destroy(); err != nil {
+			t.Errorf("got error when destroy nfs driver: %v", err)
+		}
+	})
+	volumeMetadata, err := driver.Get("legacy")
+	if err != nil {
+		t.Fatalf("got error when get volume legacy: %v", err)
+	}
+	if _, ok := volumeMetadata.Status.MountClaimedAt["old"]; !ok {
+		t.Errorf("expected claim time recorded for mount old, got %v", volumeMetadata.Status.MountClaimedAt)
+	}
+	err = driver.Remove("legacy")
+	if err == nil {
+		t.Errorf("expected error when remove volume legacy with a recent mount of an earlier version")
+	}
+
+	// Once claimTTL passed, the mount is stale
+	err = driver.(*nfs).db.SetVolumeMetadata("legacy", func(volumeMetadata *apis.VolumeMetadata) error {
+		volumeMetadata.Status.MountClaimedAt["old"] = time.Now().Add(-time.Hour)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("got error when age mount old: %v", err)
+	}
+	err = driver.Remove("legacy")
+	if err != nil {
+		t.Errorf("got error when remove volume legacy with a stale mount of an earlier version: %v", err)
+	}
+}
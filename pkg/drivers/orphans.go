@@ -0,0 +1,190 @@
+package drivers
+
+import (
+	"docker-volume-plugin/pkg/drivers/apis"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"time"
+)
+
+// removedMarker is written into the directory of a volume removed without purging its data,
+// which is then kept on purpose and not reported as an orphan
+const removedMarker = "_removed"
+
+// findOrphans returns the names of the volume directories under the root that have a data directory but no entry in
+// volumeMetadataMap, as left by a crash between creating the directory and writing the metadata.
+// Reserved paths, the data kept by removed volumes and directories without a data directory are never orphans.
+// With nested names only the parent directories of existing volumes are walked, so that other directory trees on the
+// share, such as the sources of imported volumes, are not scanned on every List.
+func (n *nfs) findOrphans(volumeMetadataMap map[string]*apis.VolumeMetadata) ([]string, error) {
+	orphans := []string{}
+
+	parents := map[string]bool{}
+	for name := range volumeMetadataMap {
+		for dir := path.Dir(name); dir != "." && dir != "/"; dir = path.Dir(dir) {
+			parents[dir] = true
+		}
+	}
+
+	var walk func(dir string) error
+	walk = func(dir string) error {
+		entries, err := os.ReadDir(path.Join(n.rootPath, dir))
+		if err != nil {
+			return err
+		}
+
+		for _, entry := range entries {
+			name := path.Join(dir, entry.Name())
+			if !entry.IsDir() || volumeMetadataMap[name] != nil || n.validateVolumeName(name) != nil {
+				continue
+			}
+			// Also skips the stale metadata kept aside on replica promotion
+			if dir == "." && slices.ContainsFunc(n.reservedPath, func(reserved string) bool { return strings.HasPrefix(name, reserved) }) {
+				continue
+			}
+
+			_, err := os.Lstat(path.Join(n.rootPath, name, "_data"))
+			if err == nil {
+				if !n.isRemoved(name) {
+					orphans = append(orphans, name)
+				}
+				continue
+			}
+
+			if n.opts.AllowNestedNames && parents[name] {
+				err = walk(name)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	err := walk(".")
+	return orphans, err
+}
+
+// isOrphan reports whether volume name has a data directory on the share but no metadata, the data kept by a removal
+// is not an orphan
+func (n *nfs) isOrphan(name string) (bool, error) {
+	if n.isRemoved(name) {
+		return false, nil
+	}
+
+	_, err := os.Lstat(path.Join(n.rootPath, name, "_data"))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to stat volume data path of %s: %v", name, err)
+	}
+
+	_, err = n.db.GetVolumeMetadata(name)
+	if errors.Is(err, apis.ErrVolumeNotFound) {
+		return true, nil
+	}
+	return false, err
+}
+
+// orphanCreatedAt returns the modification time of the directory of orphan volume name as its creation time
+func (n *nfs) orphanCreatedAt(name string) (time.Time, error) {
+	info, err := os.Stat(path.Join(n.rootPath, name))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to stat volume directory of %s: %v", name, err)
+	}
+	return info.ModTime(), nil
+}
+
+// adoptOrphan registers orphan volume name with the default options of the driver, the caller holds its volume lock
+func (n *nfs) adoptOrphan(name string) (*apis.VolumeMetadata, error) {
+	createdAt, err := n.orphanCreatedAt(name)
+	if err != nil {
+		return nil, err
+	}
+
+	backendData, err := json.Marshal(&nfsBackendData{Export: n.export()})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal backend data: %v", err)
+	}
+
+	var adopted *apis.VolumeMetadata
+	err = n.db.CreateVolumeMetadata(name, func(volumeMetadata *apis.VolumeMetadata) error {
+		*volumeMetadata = apis.VolumeMetadata{
+			Mountpoint: path.Join(name, "_data"),
+			CreatedAt:  createdAt,
+			Spec: &apis.VolumeSpec{
+				PurgeAfterDelete: n.opts.PurgeAfterDelete,
+				WarmupOnMount:    n.opts.WarmupOnMount,
+			},
+			Status:      &apis.VolumeStatus{},
+			BackendData: backendData,
+		}
+		adopted = cloneVolumeMetadata(volumeMetadata)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to adopt orphan volume directory %s: %w", name, err)
+	}
+
+	n.logger.Infof("adopted orphan volume directory %s", name)
+	n.recordHistory(name, historyAdopt, "", nil, adopted)
+
+	return adopted, nil
+}
+
+// reconcileOrphans logs the orphan volume directories, or adopts them into volumeMetadataMap with adoptOrphans.
+// The caller holds lock shared, so every orphan is checked again under its volume lock: a create or remove of it
+// may have been between its directory and its metadata when volumeMetadataMap was read.
+func (n *nfs) reconcileOrphans(volumeMetadataMap map[string]*apis.VolumeMetadata) {
+	orphans, err := n.findOrphans(volumeMetadataMap)
+	if err != nil {
+		n.logger.Warningf("failed to look for orphan volume directories: %v", err)
+	}
+
+	for _, name := range orphans {
+		volumeMetadata, err := n.reconcileOrphan(name)
+		if err != nil {
+			n.logger.Warningf("%v", err)
+			continue
+		}
+		if volumeMetadata != nil {
+			volumeMetadataMap[name] = volumeMetadata
+		}
+	}
+}
+
+// reconcileOrphan logs or adopts volume name if it is still an orphan, returning its metadata once adopted
+func (n *nfs) reconcileOrphan(name string) (*apis.VolumeMetadata, error) {
+	defer n.volumeLocks.acquire(name, false)()
+
+	orphan, err := n.isOrphan(name)
+	if err != nil || !orphan {
+		return nil, err
+	}
+
+	if !n.opts.AdoptOrphans {
+		n.logger.Warningf("volume directory %s has no metadata, enable adoptOrphans or create the volume with adoptExisting to adopt it", name)
+		return nil, nil
+	}
+	return n.adoptOrphan(name)
+}
+
+// isRemoved reports whether the directory of volume name holds the data kept by a removal
+func (n *nfs) isRemoved(name string) bool {
+	_, err := os.Lstat(path.Join(n.rootPath, name, removedMarker))
+	return err == nil
+}
+
+// markRemoved leaves removedMarker in the directory of volume name whose data is kept after removal
+func (n *nfs) markRemoved(name string) {
+	err := os.WriteFile(path.Join(n.rootPath, name, removedMarker), []byte(time.Now().Format(time.RFC3339)), 0644)
+	if err != nil && !os.IsNotExist(err) {
+		n.logger.Warningf("failed to mark kept data of removed volume %s, it is reported as orphan: %v", name, err)
+	}
+}
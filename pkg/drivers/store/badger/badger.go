@@ -2,23 +2,30 @@ package badger
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"strings"
 
 	"docker-volume-plugin/pkg/drivers/apis"
+	"docker-volume-plugin/pkg/drivers/store"
 	"docker-volume-plugin/pkg/log"
 
 	badger "github.com/dgraph-io/badger/v4"
-	"github.com/gofrs/flock"
 )
 
-type ActionCallback func(volumeMetadata *apis.VolumeMetadata) error
+// historyPrefix of the history keyspace, volume names never contain a null byte
+const historyPrefix = "\x00history\x00"
+
+type ActionCallback = store.ActionCallback
+
+var _ store.Store = &DB{}
 
 type DB struct {
 	logger               *log.Logger
 	path                 string
-	flock                *flock.Flock
 	defaultBadgerOptions badger.Options
+	lock                 *store.FileLock
+	replica              *replica
 }
 
 func NewBadgerDB(logger *log.Logger, path string, lock string) *DB {
@@ -27,25 +34,22 @@ func NewBadgerDB(logger *log.Logger, path string, lock string) *DB {
 	return &DB{
 		logger:               logger,
 		path:                 path,
-		flock:                flock.New(lock),
+		lock:                 store.NewFileLock(logger, lock),
 		defaultBadgerOptions: defaultBadgerOptions,
 	}
 }
 
 func (b *DB) CreateVolumeMetadata(name string, action ActionCallback) error {
-	err := b.flock.Lock()
+	unlock, err := b.lock.Lock()
 	if err != nil {
-		return fmt.Errorf("failed to get flock: %v", err)
+		return err
 	}
-	defer func() {
-		if err := b.flock.Unlock(); err != nil {
-			b.logger.Errorf("failed to unlock flock: %v", err)
-		}
-	}()
+	defer unlock()
+	defer b.replicate()
 
 	db, err := badger.Open(b.defaultBadgerOptions)
 	if err != nil {
-		return fmt.Errorf("failed to open badger database: %v", err)
+		return fmt.Errorf("failed to open badger database: %w", err)
 	}
 	defer func() {
 		if err := db.Close(); err != nil {
@@ -64,7 +68,7 @@ func (b *DB) CreateVolumeMetadata(name string, action ActionCallback) error {
 		return nil
 	})
 	if err != nil {
-		return fmt.Errorf("failed to open badger database: %v", err)
+		return fmt.Errorf("failed to open badger database: %w", err)
 	}
 
 	txn := db.NewTransaction(true)
@@ -73,36 +77,32 @@ func (b *DB) CreateVolumeMetadata(name string, action ActionCallback) error {
 	volumeMetadata := &apis.VolumeMetadata{}
 	err = action(volumeMetadata)
 	if err != nil {
-		return fmt.Errorf("failed to execute action: %v", err)
+		return fmt.Errorf("failed to execute action: %w", err)
 	}
 
 	value, err := json.Marshal(volumeMetadata)
 	if err != nil {
-		return fmt.Errorf("failed to marshal volume metadata: %v", err)
+		return fmt.Errorf("failed to marshal volume metadata: %w", err)
 	}
 
 	err = txn.Set([]byte(name), []byte(value))
 	if err != nil {
-		return fmt.Errorf("failed to set volume metadata in database: %v", err)
+		return fmt.Errorf("failed to set volume metadata in database: %w", err)
 	}
 
 	return txn.Commit()
 }
 
 func (b *DB) GetVolumeMetadata(name string) (*apis.VolumeMetadata, error) {
-	err := b.flock.Lock()
+	unlock, err := b.lock.Lock()
 	if err != nil {
-		return &apis.VolumeMetadata{}, fmt.Errorf("failed to get flock: %v", err)
+		return &apis.VolumeMetadata{}, err
 	}
-	defer func() {
-		if err := b.flock.Unlock(); err != nil {
-			b.logger.Errorf("failed to unlock flock: %v", err)
-		}
-	}()
+	defer unlock()
 
 	db, err := badger.Open(b.defaultBadgerOptions)
 	if err != nil {
-		return &apis.VolumeMetadata{}, fmt.Errorf("failed to open badger database: %v", err)
+		return &apis.VolumeMetadata{}, fmt.Errorf("failed to open badger database: %w", err)
 	}
 	defer func() {
 		if err := db.Close(); err != nil {
@@ -116,19 +116,15 @@ func (b *DB) GetVolumeMetadata(name string) (*apis.VolumeMetadata, error) {
 func (b *DB) GetVolumeMetadataMap() (map[string]*apis.VolumeMetadata, error) {
 	volumeMetadataMap := make(map[string]*apis.VolumeMetadata)
 
-	err := b.flock.Lock()
+	unlock, err := b.lock.Lock()
 	if err != nil {
 		return volumeMetadataMap, nil
 	}
-	defer func() {
-		if err := b.flock.Unlock(); err != nil {
-			b.logger.Errorf("failed to unlock flock: %v", err)
-		}
-	}()
+	defer unlock()
 
 	db, err := badger.Open(b.defaultBadgerOptions)
 	if err != nil {
-		return volumeMetadataMap, fmt.Errorf("failed to open badger database: %v", err)
+		return volumeMetadataMap, fmt.Errorf("failed to open badger database: %w", err)
 	}
 	defer func() {
 		if err := db.Close(); err != nil {
@@ -142,6 +138,9 @@ func (b *DB) GetVolumeMetadataMap() (map[string]*apis.VolumeMetadata, error) {
 
 		for it.Rewind(); it.Valid(); it.Next() {
 			item := it.Item()
+			if strings.HasPrefix(string(item.Key()), historyPrefix) {
+				continue
+			}
 
 			volumeMetadata := &apis.VolumeMetadata{}
 			err = item.Value(func(val []byte) error { return json.Unmarshal(val, volumeMetadata) })
@@ -159,19 +158,16 @@ func (b *DB) GetVolumeMetadataMap() (map[string]*apis.VolumeMetadata, error) {
 }
 
 func (b *DB) SetVolumeMetadata(name string, action ActionCallback) error {
-	err := b.flock.Lock()
+	unlock, err := b.lock.Lock()
 	if err != nil {
-		return fmt.Errorf("failed to get flock: %v", err)
+		return err
 	}
-	defer func() {
-		if err := b.flock.Unlock(); err != nil {
-			b.logger.Errorf("failed to unlock flock: %v", err)
-		}
-	}()
+	defer unlock()
+	defer b.replicate()
 
 	db, err := badger.Open(b.defaultBadgerOptions)
 	if err != nil {
-		return fmt.Errorf("failed to open badger database: %v", err)
+		return fmt.Errorf("failed to open badger database: %w", err)
 	}
 	defer func() {
 		if err := db.Close(); err != nil {
@@ -189,36 +185,33 @@ func (b *DB) SetVolumeMetadata(name string, action ActionCallback) error {
 
 	err = action(volumeMetadata)
 	if err != nil {
-		return fmt.Errorf("failed to execute action: %v", err)
+		return fmt.Errorf("failed to execute action: %w", err)
 	}
 
 	value, err := json.Marshal(volumeMetadata)
 	if err != nil {
-		return fmt.Errorf("failed to marshal volume metadata: %v", err)
+		return fmt.Errorf("failed to marshal volume metadata: %w", err)
 	}
 
 	err = txn.Set([]byte(name), []byte(value))
 	if err != nil {
-		return fmt.Errorf("failed to set volume metadata in database: %v", err)
+		return fmt.Errorf("failed to set volume metadata in database: %w", err)
 	}
 
 	return txn.Commit()
 }
 
 func (b *DB) DeleteVolumeMetadata(name string, action ActionCallback) error {
-	err := b.flock.Lock()
+	unlock, err := b.lock.Lock()
 	if err != nil {
-		return fmt.Errorf("failed to get flock: %v", err)
+		return err
 	}
-	defer func() {
-		if err := b.flock.Unlock(); err != nil {
-			b.logger.Errorf("failed to unlock flock: %v", err)
-		}
-	}()
+	defer unlock()
+	defer b.replicate()
 
 	db, err := badger.Open(b.defaultBadgerOptions)
 	if err != nil {
-		return fmt.Errorf("failed to open badger database: %v", err)
+		return fmt.Errorf("failed to open badger database: %w", err)
 	}
 	defer func() {
 		if err := db.Close(); err != nil {
@@ -236,19 +229,195 @@ func (b *DB) DeleteVolumeMetadata(name string, action ActionCallback) error {
 
 	err = txn.Delete([]byte(name))
 	if err != nil {
-		return fmt.Errorf("failed to delete volume metadata in database: %v", err)
+		return fmt.Errorf("failed to delete volume metadata in database: %w", err)
 	}
 
 	err = action(volumeMetadata)
 	if err != nil {
-		return fmt.Errorf("failed to execute action: %v", err)
+		return fmt.Errorf("failed to execute action: %w", err)
 	}
 
 	return txn.Commit()
 }
 
+// Recover checks whether the database logs need truncation after an unclean shutdown, such as a node dying mid-write.
+// Truncation drops partially written entries, so recent metadata writes may be lost and it is only done when truncate is set.
+func (b *DB) Recover(truncate bool) error {
+	unlock, err := b.lock.Lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	db, err := badger.Open(b.defaultBadgerOptions.WithReadOnly(true))
+	if err == nil {
+		return db.Close()
+	}
+	// badger flattens wrapped errors into their message
+	if !strings.Contains(err.Error(), badger.ErrTruncateNeeded.Error()) {
+		// Missing databases and other failures are left to the regular open
+		b.logger.Debugf("skip recovery check of badger database: %v", err)
+		return nil
+	}
+
+	if !truncate {
+		return fmt.Errorf("badger database %s needs log truncation after an unclean shutdown, which may lose recent writes: %w", b.path, err)
+	}
+
+	b.logger.Errorf("badger database %s was not shut down cleanly, truncating its logs: metadata writes in flight during the crash may be lost", b.path)
+	db, err = badger.Open(b.defaultBadgerOptions)
+	if err != nil {
+		return fmt.Errorf("failed to open badger database: %w", err)
+	}
+	return db.Close()
+}
+
+// AppendVolumeHistory appends entry to the history of volume name, assigning its version and keeping at most depth entries
+func (b *DB) AppendVolumeHistory(name string, entry *apis.VolumeHistoryEntry, depth int) error {
+	unlock, err := b.lock.Lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	defer b.replicate()
+
+	db, err := badger.Open(b.defaultBadgerOptions)
+	if err != nil {
+		return fmt.Errorf("failed to open badger database: %w", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			b.logger.Errorf("failed to close badger database: %v", err)
+		}
+	}()
+
+	return db.Update(func(txn *badger.Txn) error {
+		historyEntries, err := getVolumeHistory(txn, name)
+		if err != nil {
+			return err
+		}
+
+		entry.Version = 1
+		if len(historyEntries) != 0 {
+			entry.Version = historyEntries[len(historyEntries)-1].Version + 1
+		}
+
+		value, err := json.Marshal(entry)
+		if err != nil {
+			return fmt.Errorf("failed to marshal volume history: %w", err)
+		}
+
+		err = txn.Set(historyKey(name, entry.Version), value)
+		if err != nil {
+			return fmt.Errorf("failed to set volume history in database: %w", err)
+		}
+
+		for i := 0; i < len(historyEntries)+1-depth; i++ {
+			err = txn.Delete(historyKey(name, historyEntries[i].Version))
+			if err != nil {
+				return fmt.Errorf("failed to prune volume history in database: %w", err)
+			}
+		}
+
+		return nil
+	})
+}
+
+// GetVolumeHistory returns the history of volume name, oldest first
+func (b *DB) GetVolumeHistory(name string) ([]*apis.VolumeHistoryEntry, error) {
+	unlock, err := b.lock.Lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	db, err := badger.Open(b.defaultBadgerOptions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open badger database: %w", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			b.logger.Errorf("failed to close badger database: %v", err)
+		}
+	}()
+
+	historyEntries := []*apis.VolumeHistoryEntry{}
+	err = db.View(func(txn *badger.Txn) error {
+		historyEntries, err = getVolumeHistory(txn, name)
+		return err
+	})
+
+	return historyEntries, err
+}
+
+// Export returns the metadata and history of all volumes, the database is opened read-only and left untouched
+func (b *DB) Export() (map[string]*apis.VolumeMetadata, map[string][]*apis.VolumeHistoryEntry, error) {
+	volumeMetadataMap := map[string]*apis.VolumeMetadata{}
+	historyMap := map[string][]*apis.VolumeHistoryEntry{}
+
+	unlock, err := b.lock.Lock()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer unlock()
+
+	db, err := badger.Open(b.defaultBadgerOptions.WithReadOnly(true))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open badger database: %w", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			b.logger.Errorf("failed to close badger database: %v", err)
+		}
+	}()
+
+	err = db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		// History keys sort by version within a volume, so the entries are appended oldest first
+		for it.Rewind(); it.Valid(); it.Next() {
+			key := string(it.Item().Key())
+			if historyKey, ok := strings.CutPrefix(key, historyPrefix); ok {
+				end := strings.LastIndex(historyKey, "\x00")
+				if end < 0 {
+					continue
+				}
+				name := historyKey[:end]
+				historyEntry := &apis.VolumeHistoryEntry{}
+				err := it.Item().Value(func(val []byte) error { return json.Unmarshal(val, historyEntry) })
+				if err != nil {
+					return err
+				}
+				historyMap[name] = append(historyMap[name], historyEntry)
+				continue
+			}
+
+			volumeMetadata := &apis.VolumeMetadata{}
+			err := it.Item().Value(func(val []byte) error { return json.Unmarshal(val, volumeMetadata) })
+			if err != nil {
+				return err
+			}
+			volumeMetadataMap[key] = volumeMetadata
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return volumeMetadataMap, historyMap, nil
+}
+
+// Reopen replaces the handle of the lock file, which goes stale when the filesystem holding it is mounted again
+func (b *DB) Reopen() {
+	b.lock.Reopen()
+}
+
 func (b *DB) Close() error {
-	return b.flock.Close()
+	b.closeReplica()
+	return b.lock.Close()
 }
 
 func getVolumeMetadata(db *badger.DB, name string) (*apis.VolumeMetadata, error) {
@@ -256,14 +425,39 @@ func getVolumeMetadata(db *badger.DB, name string) (*apis.VolumeMetadata, error)
 
 	err := db.View(func(txn *badger.Txn) error {
 		item, err := txn.Get([]byte(name))
+		if errors.Is(err, badger.ErrKeyNotFound) || err == nil && item == nil {
+			return fmt.Errorf("%w: %s", apis.ErrVolumeNotFound, name)
+		}
 		if err != nil {
 			return err
 		}
-		if item == nil {
-			return fmt.Errorf("volume %s not found", name)
-		}
 		return item.Value(func(val []byte) error { return json.Unmarshal(val, volumeMetadata) })
 	})
 
 	return volumeMetadata, err
 }
+
+// historyKey of a version of the history of volume name, zero padded so that keys sort by version
+func historyKey(name string, version int) []byte {
+	return []byte(fmt.Sprintf("%s%s\x00%020d", historyPrefix, name, version))
+}
+
+func getVolumeHistory(txn *badger.Txn, name string) ([]*apis.VolumeHistoryEntry, error) {
+	historyEntries := []*apis.VolumeHistoryEntry{}
+
+	prefix := []byte(historyPrefix + name + "\x00")
+	it := txn.NewIterator(badger.IteratorOptions{Prefix: prefix, PrefetchValues: true, PrefetchSize: 100})
+	defer it.Close()
+
+	for it.Rewind(); it.Valid(); it.Next() {
+		historyEntry := &apis.VolumeHistoryEntry{}
+		err := it.Item().Value(func(val []byte) error { return json.Unmarshal(val, historyEntry) })
+		if err != nil {
+			return historyEntries, err
+		}
+
+		historyEntries = append(historyEntries, historyEntry)
+	}
+
+	return historyEntries, nil
+}
@@ -0,0 +1,213 @@
+package badger
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"docker-volume-plugin/pkg/utils"
+
+	badger "github.com/dgraph-io/badger/v4"
+)
+
+// replica is a backup of the database kept at another location and refreshed in background after every write
+type replica struct {
+	path    string
+	lock    sync.Mutex
+	closed  bool
+	pending chan struct{}
+	done    chan struct{}
+}
+
+// EnableReplica keeps a warm standby copy of the database at replicaPath.
+// A replica newer than the database, as left behind when the database was lost, is promoted when promote is set
+// and otherwise left untouched with replication disabled, so it is still there to promote on a later start.
+func (b *DB) EnableReplica(replicaPath string, promote bool) error {
+	err := os.MkdirAll(path.Dir(replicaPath), 0755)
+	if err != nil {
+		return fmt.Errorf("failed to create metadata replica directory: %w", err)
+	}
+
+	newer, err := b.checkReplica(replicaPath, promote)
+	if err != nil {
+		return err
+	}
+	if newer {
+		b.logger.Warningf("metadata replica %s is newer than the database, enable promotion to fail over to it, replication is disabled until then", replicaPath)
+		return nil
+	}
+
+	b.replica = &replica{
+		path:    replicaPath,
+		pending: make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+	go func() {
+		defer close(b.replica.done)
+
+		for range b.replica.pending {
+			if err := b.backupReplica(); err != nil {
+				b.logger.Errorf("failed to replicate metadata to %s: %v", b.replica.path, err)
+			}
+		}
+	}()
+
+	// Bring the replica up to date with writes made before it was enabled
+	b.replicate()
+
+	return nil
+}
+
+// replicate schedules a refresh of the replica, writes made while a refresh is pending are coalesced into it
+func (b *DB) replicate() {
+	if b.replica == nil {
+		return
+	}
+
+	b.replica.lock.Lock()
+	defer b.replica.lock.Unlock()
+
+	if b.replica.closed {
+		return
+	}
+
+	select {
+	case b.replica.pending <- struct{}{}:
+	default:
+	}
+}
+
+// closeReplica waits for the pending refresh of the replica
+func (b *DB) closeReplica() {
+	if b.replica == nil {
+		return
+	}
+
+	b.replica.lock.Lock()
+	if !b.replica.closed {
+		b.replica.closed = true
+		close(b.replica.pending)
+	}
+	b.replica.lock.Unlock()
+
+	<-b.replica.done
+}
+
+// backupReplica replaces the replica with a backup of the database, recording the database version it was taken at
+func (b *DB) backupReplica() error {
+	unlock, err := b.lock.Lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	db, err := badger.Open(b.defaultBadgerOptions)
+	if err != nil {
+		return fmt.Errorf("failed to open badger database: %w", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			b.logger.Errorf("failed to close badger database: %v", err)
+		}
+	}()
+
+	err = utils.WriteFileAtomic(b.replica.path, func(file *os.File) error {
+		_, err := db.Backup(file, 0)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write metadata replica: %w", err)
+	}
+
+	// The version is written after the backup, so a crash in between understates the replica and never overstates it
+	version := db.MaxVersion()
+	err = utils.WriteFileAtomic(replicaVersionPath(b.replica.path), func(file *os.File) error {
+		_, err := file.WriteString(strconv.FormatUint(version, 10))
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write metadata replica version: %w", err)
+	}
+
+	return nil
+}
+
+// checkReplica promotes the replica at replicaPath in place of the database when it is newer and promote is set,
+// and reports whether a newer replica was left unpromoted
+func (b *DB) checkReplica(replicaPath string, promote bool) (bool, error) {
+	content, err := os.ReadFile(replicaVersionPath(replicaPath))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to read metadata replica version: %w", err)
+	}
+	replicaVersion, err := strconv.ParseUint(strings.TrimSpace(string(content)), 10, 64)
+	if err != nil {
+		return false, fmt.Errorf("failed to parse metadata replica version: %w", err)
+	}
+
+	unlock, err := b.lock.Lock()
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	db, err := badger.Open(b.defaultBadgerOptions)
+	if err != nil {
+		return false, fmt.Errorf("failed to open badger database: %w", err)
+	}
+	version := db.MaxVersion()
+	err = db.Close()
+	if err != nil {
+		return false, fmt.Errorf("failed to close badger database: %w", err)
+	}
+
+	if replicaVersion <= version {
+		return false, nil
+	}
+
+	if !promote {
+		return true, nil
+	}
+
+	b.logger.Warningf("promote metadata replica %s at version %d over the database at version %d", replicaPath, replicaVersion, version)
+
+	// Keep the stale database aside instead of deleting it
+	stalePath := fmt.Sprintf("%s.stale-%d", b.path, time.Now().Unix())
+	err = os.Rename(b.path, stalePath)
+	if err != nil {
+		return false, fmt.Errorf("failed to move stale database to %s: %w", stalePath, err)
+	}
+
+	file, err := os.Open(replicaPath)
+	if err != nil {
+		return false, fmt.Errorf("failed to open metadata replica: %w", err)
+	}
+	defer file.Close()
+
+	db, err = badger.Open(b.defaultBadgerOptions)
+	if err != nil {
+		return false, fmt.Errorf("failed to open badger database: %w", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			b.logger.Errorf("failed to close badger database: %v", err)
+		}
+	}()
+
+	err = db.Load(file, 256)
+	if err != nil {
+		return false, fmt.Errorf("failed to load metadata replica: %w", err)
+	}
+
+	return false, nil
+}
+
+func replicaVersionPath(replicaPath string) string {
+	return replicaPath + ".version"
+}
@@ -0,0 +1,69 @@
+package store
+
+import (
+	"docker-volume-plugin/pkg/log"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gofrs/flock"
+)
+
+// FileLock serializes the accesses to a store, within this process with a mutex, which the flock does not since its
+// goroutines share it, and with the other processes with a flock on a lock file
+type FileLock struct {
+	logger *log.Logger
+	path   string
+	// handle is replaced as a whole by Reopen, accesses keep the handle they locked until they release it
+	handle atomic.Pointer[fileLockHandle]
+}
+
+type fileLockHandle struct {
+	mutex sync.Mutex
+	flock *flock.Flock
+}
+
+func NewFileLock(logger *log.Logger, path string) *FileLock {
+	f := &FileLock{logger: logger, path: path}
+	f.handle.Store(&fileLockHandle{flock: flock.New(path)})
+	return f
+}
+
+// Lock takes the lock and returns the function releasing it
+func (f *FileLock) Lock() (func(), error) {
+	handle := f.handle.Load()
+	handle.mutex.Lock()
+
+	err := handle.flock.Lock()
+	if err != nil {
+		handle.mutex.Unlock()
+		return nil, fmt.Errorf("failed to get flock: %w", err)
+	}
+
+	return func() {
+		if err := handle.flock.Unlock(); err != nil {
+			f.logger.Errorf("failed to unlock flock: %v", err)
+		}
+		handle.mutex.Unlock()
+	}, nil
+}
+
+// Reopen replaces the handle of the lock file, which goes stale when the filesystem holding it is mounted again.
+// An access hanging on the old filesystem may hold the stale handle forever, so Reopen does not wait for it: accesses
+// from now on use the new handle, and the stale one is closed once released.
+func (f *FileLock) Reopen() {
+	stale := f.handle.Swap(&fileLockHandle{flock: flock.New(f.path)})
+
+	go func() {
+		stale.mutex.Lock()
+		defer stale.mutex.Unlock()
+
+		if err := stale.flock.Close(); err != nil {
+			f.logger.Warningf("failed to close stale flock: %v", err)
+		}
+	}()
+}
+
+func (f *FileLock) Close() error {
+	return f.handle.Load().flock.Close()
+}
@@ -0,0 +1,53 @@
+package store
+
+import (
+	"docker-volume-plugin/pkg/log"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestFileLockReopen(t *testing.T) {
+	lock := NewFileLock(log.New("test-filelock"), path.Join(t.TempDir(), "metadata.lock"))
+	defer func() {
+		if err := lock.Close(); err != nil {
+			t.Errorf("got error when close file lock: %v", err)
+		}
+	}()
+
+	// An access hanging on the old mount holds the lock while the share is mounted again
+	unlock, err := lock.Lock()
+	if err != nil {
+		t.Fatalf("got error when lock: %v", err)
+	}
+
+	reopened := make(chan struct{})
+	go func() {
+		lock.Reopen()
+		close(reopened)
+	}()
+	select {
+	case <-reopened:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected reopen not blocked by the access holding the lock")
+	}
+
+	// Both handles lock the same file here, so the access on the new handle waits for the old one to be released
+	locked := make(chan error, 1)
+	go func() {
+		unlock, err := lock.Lock()
+		if err == nil {
+			unlock()
+		}
+		locked <- err
+	}()
+	unlock()
+	select {
+	case err = <-locked:
+		if err != nil {
+			t.Errorf("got error when lock after reopen: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("expected lock after reopen once the stale handle is released")
+	}
+}
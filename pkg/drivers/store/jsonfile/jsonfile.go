@@ -0,0 +1,245 @@
+package jsonfile
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"docker-volume-plugin/pkg/drivers/apis"
+	"docker-volume-plugin/pkg/drivers/store"
+	"docker-volume-plugin/pkg/log"
+	"docker-volume-plugin/pkg/utils"
+)
+
+var _ store.Store = &DB{}
+
+// DB keeps the metadata in a single JSON document, readable with any text tool while the plugin is down
+type DB struct {
+	logger *log.Logger
+	path   string
+	lock   *store.FileLock
+}
+
+// document is the content of the metadata file
+type document struct {
+	Volumes map[string]*apis.VolumeMetadata       `json:"volumes"`
+	History map[string][]*apis.VolumeHistoryEntry `json:"history,omitempty"`
+}
+
+func NewJSONFileDB(logger *log.Logger, path string, lock string) *DB {
+	return &DB{
+		logger: logger,
+		path:   path,
+		lock:   store.NewFileLock(logger, lock),
+	}
+}
+
+func (j *DB) CreateVolumeMetadata(name string, action store.ActionCallback) error {
+	return j.update(func(doc *document) error {
+		if doc.Volumes[name] != nil {
+			return fmt.Errorf("volume %s already created", name)
+		}
+
+		volumeMetadata := &apis.VolumeMetadata{}
+		err := action(volumeMetadata)
+		if err != nil {
+			return fmt.Errorf("failed to execute action: %w", err)
+		}
+
+		doc.Volumes[name] = volumeMetadata
+		return nil
+	})
+}
+
+func (j *DB) GetVolumeMetadata(name string) (*apis.VolumeMetadata, error) {
+	volumeMetadata := &apis.VolumeMetadata{}
+	err := j.view(func(doc *document) error {
+		if doc.Volumes[name] == nil {
+			return fmt.Errorf("%w: %s", apis.ErrVolumeNotFound, name)
+		}
+		volumeMetadata = doc.Volumes[name]
+		return nil
+	})
+
+	return volumeMetadata, err
+}
+
+func (j *DB) GetVolumeMetadataMap() (map[string]*apis.VolumeMetadata, error) {
+	volumeMetadataMap := make(map[string]*apis.VolumeMetadata)
+	err := j.view(func(doc *document) error {
+		volumeMetadataMap = doc.Volumes
+		return nil
+	})
+
+	return volumeMetadataMap, err
+}
+
+func (j *DB) SetVolumeMetadata(name string, action store.ActionCallback) error {
+	return j.update(func(doc *document) error {
+		volumeMetadata := doc.Volumes[name]
+		if volumeMetadata == nil {
+			return fmt.Errorf("failed to get %s volume metadata: %w: %s", name, apis.ErrVolumeNotFound, name)
+		}
+
+		err := action(volumeMetadata)
+		if err != nil {
+			return fmt.Errorf("failed to execute action: %w", err)
+		}
+		return nil
+	})
+}
+
+func (j *DB) DeleteVolumeMetadata(name string, action store.ActionCallback) error {
+	return j.update(func(doc *document) error {
+		volumeMetadata := doc.Volumes[name]
+		if volumeMetadata == nil {
+			return fmt.Errorf("failed to get %s volume metadata: %w: %s", name, apis.ErrVolumeNotFound, name)
+		}
+
+		err := action(volumeMetadata)
+		if err != nil {
+			return fmt.Errorf("failed to execute action: %w", err)
+		}
+
+		delete(doc.Volumes, name)
+		return nil
+	})
+}
+
+// AppendVolumeHistory appends entry to the history of volume name, assigning its version and keeping at most depth entries
+func (j *DB) AppendVolumeHistory(name string, entry *apis.VolumeHistoryEntry, depth int) error {
+	return j.update(func(doc *document) error {
+		historyEntries := doc.History[name]
+
+		entry.Version = 1
+		if len(historyEntries) != 0 {
+			entry.Version = historyEntries[len(historyEntries)-1].Version + 1
+		}
+
+		historyEntries = append(historyEntries, entry)
+		if len(historyEntries) > depth {
+			historyEntries = historyEntries[len(historyEntries)-depth:]
+		}
+		doc.History[name] = historyEntries
+		return nil
+	})
+}
+
+// GetVolumeHistory returns the history of volume name, oldest first
+func (j *DB) GetVolumeHistory(name string) ([]*apis.VolumeHistoryEntry, error) {
+	historyEntries := []*apis.VolumeHistoryEntry{}
+	err := j.view(func(doc *document) error {
+		historyEntries = append(historyEntries, doc.History[name]...)
+		return nil
+	})
+
+	return historyEntries, err
+}
+
+// Import writes volumeMetadataMap and historyMap as the initial content of the store, unless the metadata file
+// already exists, and reports whether it did
+func (j *DB) Import(volumeMetadataMap map[string]*apis.VolumeMetadata, historyMap map[string][]*apis.VolumeHistoryEntry) (bool, error) {
+	imported := false
+	err := j.locked(func() error {
+		_, err := os.Stat(j.path)
+		if err == nil || !os.IsNotExist(err) {
+			return err
+		}
+
+		doc := &document{Volumes: volumeMetadataMap, History: historyMap}
+		if doc.Volumes == nil {
+			doc.Volumes = map[string]*apis.VolumeMetadata{}
+		}
+		imported = true
+		return j.write(doc)
+	})
+
+	return imported, err
+}
+
+// Reopen replaces the handle of the lock file, which goes stale when the filesystem holding it is mounted again
+func (j *DB) Reopen() {
+	j.lock.Reopen()
+}
+
+func (j *DB) Close() error {
+	return j.lock.Close()
+}
+
+// locked runs fn holding the lock and the flock
+func (j *DB) locked(fn func() error) error {
+	unlock, err := j.lock.Lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return fn()
+}
+
+// view runs fn on the current document
+func (j *DB) view(fn func(doc *document) error) error {
+	return j.locked(func() error {
+		doc, err := j.read()
+		if err != nil {
+			return err
+		}
+		return fn(doc)
+	})
+}
+
+// update runs fn on the current document and writes the changed document, unless fn returns an error
+func (j *DB) update(fn func(doc *document) error) error {
+	return j.locked(func() error {
+		doc, err := j.read()
+		if err != nil {
+			return err
+		}
+
+		err = fn(doc)
+		if err != nil {
+			return err
+		}
+		return j.write(doc)
+	})
+}
+
+// read returns the document in the metadata file, a missing file is an empty document
+func (j *DB) read() (*document, error) {
+	doc := &document{}
+	content, err := os.ReadFile(j.path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read metadata file: %w", err)
+	}
+	if err == nil {
+		err = json.Unmarshal(content, doc)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse metadata file %s: %w", j.path, err)
+		}
+	}
+
+	if doc.Volumes == nil {
+		doc.Volumes = map[string]*apis.VolumeMetadata{}
+	}
+	if doc.History == nil {
+		doc.History = map[string][]*apis.VolumeHistoryEntry{}
+	}
+	return doc, nil
+}
+
+// write replaces the metadata file with doc through a rename, so readers and a crash see the old or new document only
+func (j *DB) write(doc *document) error {
+	content, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal metadata: %w", err)
+	}
+
+	err = utils.WriteFileAtomic(j.path, func(file *os.File) error {
+		_, err := file.Write(content)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write metadata file: %w", err)
+	}
+	return nil
+}
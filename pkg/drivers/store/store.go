@@ -0,0 +1,29 @@
+package store
+
+import "docker-volume-plugin/pkg/drivers/apis"
+
+// ActionCallback changes the metadata of a volume within a write of the store, an error aborts the write
+type ActionCallback func(volumeMetadata *apis.VolumeMetadata) error
+
+// Store keeps the metadata and change history of the volumes of a driver.
+// Every call is atomic and serialized against the other processes sharing the store.
+type Store interface {
+	// CreateVolumeMetadata stores the metadata filled by action for volume name, which must not exist yet
+	CreateVolumeMetadata(name string, action ActionCallback) error
+	// GetVolumeMetadata returns the metadata of volume name, or an error wrapping apis.ErrVolumeNotFound
+	GetVolumeMetadata(name string) (*apis.VolumeMetadata, error)
+	// GetVolumeMetadataMap returns the metadata of all volumes by name
+	GetVolumeMetadataMap() (map[string]*apis.VolumeMetadata, error)
+	// SetVolumeMetadata stores the metadata of volume name as changed by action
+	SetVolumeMetadata(name string, action ActionCallback) error
+	// DeleteVolumeMetadata deletes the metadata of volume name once action accepted it
+	DeleteVolumeMetadata(name string, action ActionCallback) error
+	// AppendVolumeHistory appends entry to the history of volume name, assigning its version and keeping at most depth entries
+	AppendVolumeHistory(name string, entry *apis.VolumeHistoryEntry, depth int) error
+	// GetVolumeHistory returns the history of volume name, oldest first
+	GetVolumeHistory(name string) ([]*apis.VolumeHistoryEntry, error)
+	// Reopen replaces the handle of the lock file, which goes stale when the filesystem holding it is mounted again.
+	// It does not wait for the accesses in progress, which may hang on the old filesystem.
+	Reopen()
+	Close() error
+}
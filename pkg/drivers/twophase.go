@@ -0,0 +1,132 @@
+package drivers
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// fsChange is a filesystem change kept consistent with a metadata transaction.
+// Stage runs before the transaction, Commit runs after it succeeds and Rollback runs after it fails.
+type fsChange struct {
+	Stage    func() error
+	Commit   func() error
+	Rollback func() error
+}
+
+// runTwoPhase stages change, runs txn and then commits or rolls back change based on the outcome of txn.
+func runTwoPhase(change fsChange, txn func() error) error {
+	if change.Stage != nil {
+		err := change.Stage()
+		if err != nil {
+			return err
+		}
+	}
+
+	err := txn()
+	if err != nil {
+		if change.Rollback != nil {
+			rollbackErr := change.Rollback()
+			if rollbackErr != nil {
+				return fmt.Errorf("%w, and failed to roll back filesystem change: %v", err, rollbackErr)
+			}
+		}
+		return err
+	}
+
+	if change.Commit != nil {
+		return change.Commit()
+	}
+	return nil
+}
+
+// createDirectoryChange creates the data directory of volume name under rootPath,
+// rolling back by removing only the directories it created.
+func createDirectoryChange(rootPath string, name string) fsChange {
+	created := ""
+	return fsChange{
+		Stage: func() error {
+			created = firstMissingDirectory(rootPath, name)
+
+			err := os.MkdirAll(path.Join(rootPath, name, "_data"), 0755)
+			if err != nil {
+				return fmt.Errorf("failed to create volume data directory: %w", err)
+			}
+			return nil
+		},
+		Rollback: func() error {
+			if created == "" {
+				return nil
+			}
+			return os.RemoveAll(created)
+		},
+	}
+}
+
+// importDirectoryChange adopts the directory importPath under rootPath as the data directory of volume name, moving it
+// when move is set and linking to it otherwise, rolling back by restoring it and removing the directories it created.
+func importDirectoryChange(rootPath string, name string, importPath string, move bool) fsChange {
+	created := ""
+	volumePath := path.Join(rootPath, name)
+	dataPath := path.Join(volumePath, "_data")
+	importDir := path.Join(rootPath, importPath)
+	return fsChange{
+		Stage: func() error {
+			created = firstMissingDirectory(rootPath, name)
+
+			err := os.MkdirAll(volumePath, 0755)
+			if err != nil {
+				return fmt.Errorf("failed to create volume directory: %w", err)
+			}
+
+			if move {
+				err = os.Rename(importDir, dataPath)
+			} else {
+				// A relative link resolves on every node, whatever the local mount point of the share
+				var target string
+				target, err = filepath.Rel(volumePath, importDir)
+				if err == nil {
+					err = os.Symlink(target, dataPath)
+				}
+			}
+			if err != nil {
+				if created != "" {
+					os.RemoveAll(created)
+				}
+				return fmt.Errorf("failed to import %s as volume data: %w", importPath, err)
+			}
+			return nil
+		},
+		Rollback: func() error {
+			var err error
+			if move {
+				err = os.Rename(dataPath, importDir)
+			} else {
+				err = os.Remove(dataPath)
+			}
+			if err != nil {
+				return err
+			}
+
+			if created == "" {
+				return nil
+			}
+			return os.RemoveAll(created)
+		},
+	}
+}
+
+// firstMissingDirectory returns the topmost directory of the path of volume name under rootPath that does not exist
+// yet, or an empty string when the volume directory exists
+func firstMissingDirectory(rootPath string, name string) string {
+	missing := ""
+	for dir := name; dir != "."; dir = path.Dir(dir) {
+		_, err := os.Stat(path.Join(rootPath, dir))
+		if !os.IsNotExist(err) {
+			break
+		}
+		missing = path.Join(rootPath, dir)
+	}
+	return missing
+}
@@ -0,0 +1,116 @@
+package drivers
+
+import (
+	"context"
+	"docker-volume-plugin/pkg/drivers/apis"
+	"docker-volume-plugin/pkg/utils"
+	"path"
+	"time"
+)
+
+// withUsage fills in the disk usage of volume name when its cached usage is older than usageTTL.
+// Walking the data is expensive, so it must be called without holding any lock.
+func (n *nfs) withUsage(ctx context.Context, name string, volumeMetadata *apis.VolumeMetadata) {
+	if volumeMetadata.Status.Usage != nil && time.Since(volumeMetadata.Status.Usage.UpdatedAt) < n.usageTTL {
+		return
+	}
+
+	n.refreshUsage(ctx, name, volumeMetadata)
+}
+
+// refreshUsage walks the data of volume name, checks it against the size of the volume and caches the usage
+// in the metadata, only taking the lock of the volume to store the result. The walk stops once ctx is done.
+func (n *nfs) refreshUsage(ctx context.Context, name string, volumeMetadata *apis.VolumeMetadata) {
+	used, total, free, err := utils.DiskUsage(ctx, path.Join(n.rootPath, name, "_data"))
+	if err != nil {
+		if ctx.Err() == nil {
+			n.logger.Warningf("failed to get disk usage of volume %s: %v", name, err)
+		}
+		return
+	}
+
+	usage := &apis.UsageStatus{
+		UsedBytes:  used,
+		TotalBytes: total,
+		FreeBytes:  free,
+		LimitBytes: volumeMetadata.Spec.SizeBytes,
+		OverQuota:  volumeMetadata.Spec.SizeBytes != 0 && used > volumeMetadata.Spec.SizeBytes,
+		UpdatedAt:  time.Now(),
+	}
+	if usage.OverQuota && (volumeMetadata.Status.Usage == nil || !volumeMetadata.Status.Usage.OverQuota) {
+		n.logger.Warningf("volume %s is over its size: %d bytes used of %d bytes", name, used, usage.LimitBytes)
+	}
+	volumeMetadata.Status.Usage = usage
+
+	defer n.lockVolume(name, false)()
+
+	if n.closed || n.checkBackend() != nil {
+		return
+	}
+
+	err = n.db.SetVolumeMetadata(name, func(volumeMetadata *apis.VolumeMetadata) error {
+		volumeMetadata.Status.Usage = usage
+		return nil
+	})
+	if err != nil {
+		n.logger.Warningf("failed to cache disk usage of volume %s: %v", name, err)
+	}
+}
+
+// usageCheck refreshes the usage of the volumes with a size every usageTTL until ctx is done,
+// so volumes growing over their size are reported without waiting for a Get or List
+func (n *nfs) usageCheck(ctx context.Context) {
+	defer close(n.usageCheckDone)
+
+	ticker := time.NewTicker(n.usageTTL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		volumeMetadataMap, err := n.sizedVolumes()
+		if err != nil {
+			n.logger.Warningf("failed to list volumes to check their usage: %v", err)
+			continue
+		}
+
+		for name, volumeMetadata := range volumeMetadataMap {
+			if ctx.Err() != nil {
+				return
+			}
+			n.refreshUsage(ctx, name, volumeMetadata)
+		}
+	}
+}
+
+// sizedVolumes returns the metadata of the volumes with a size
+func (n *nfs) sizedVolumes() (map[string]*apis.VolumeMetadata, error) {
+	n.lock.RLock()
+	defer n.lock.RUnlock()
+
+	if n.closed {
+		return nil, nil
+	}
+
+	err := n.checkBackend()
+	if err != nil {
+		return nil, err
+	}
+
+	volumeMetadataMap, err := n.db.GetVolumeMetadataMap()
+	if err != nil {
+		return nil, err
+	}
+
+	for name, volumeMetadata := range volumeMetadataMap {
+		if volumeMetadata.Spec.SizeBytes == 0 || n.validateVolumeName(name) != nil {
+			delete(volumeMetadataMap, name)
+		}
+	}
+
+	return volumeMetadataMap, nil
+}
@@ -0,0 +1,85 @@
+package drivers
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+)
+
+const (
+	warmupRunning   = "running"
+	warmupCompleted = "completed"
+	warmupLimited   = "limited"
+	warmupCanceled  = "canceled"
+	warmupFailed    = "failed"
+)
+
+var errWarmupLimit = errors.New("warmup size limit reached")
+
+// warmup is a background prefetch of volume data into the page cache
+type warmup struct {
+	cancel context.CancelFunc
+	bytes  atomic.Int64
+}
+
+// prefetch reads the regular files under root until maxBytes have been read, counting into bytes,
+// and returns the final warmup state.
+func prefetch(ctx context.Context, root string, maxBytes int64, bytes *atomic.Int64) (string, error) {
+	buf := make([]byte, 1<<20)
+
+	// The data of an imported volume is a link to the adopted directory
+	root, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return warmupFailed, err
+	}
+
+	err = filepath.WalkDir(root, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+
+		file, err := os.Open(filePath)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		for {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			remaining := maxBytes - bytes.Load()
+			if remaining <= 0 {
+				return errWarmupLimit
+			}
+
+			n, err := file.Read(buf[:min(int64(len(buf)), remaining)])
+			bytes.Add(int64(n))
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+		}
+	})
+
+	switch {
+	case err == nil:
+		return warmupCompleted, nil
+	case errors.Is(err, errWarmupLimit), errors.Is(err, context.DeadlineExceeded):
+		return warmupLimited, nil
+	case errors.Is(err, context.Canceled):
+		return warmupCanceled, nil
+	default:
+		return warmupFailed, err
+	}
+}
@@ -0,0 +1,103 @@
+package drivers
+
+import (
+	"bytes"
+	"docker-volume-plugin/pkg/log"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+type mountWebhookOptions struct {
+	// URL of the webhook, called with a POST request before every mount
+	URL string `json:"url"`
+	// Timeout of a webhook call, default is 5s
+	Timeout string `json:"timeout,omitempty"`
+	// FailClosed indicates whether to deny the mount when the webhook is unreachable or fails, default is false
+	FailClosed bool `json:"failClosed,omitempty"`
+}
+
+type mountAdmissionRequest struct {
+	Name string `json:"name"`
+	ID   string `json:"id"`
+}
+
+type mountAdmissionResponse struct {
+	Allowed bool   `json:"allowed"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// mountWebhook is an inline gate that lets an external orchestrator approve or deny a mount
+type mountWebhook struct {
+	logger     *log.Logger
+	url        string
+	failClosed bool
+	client     *http.Client
+}
+
+func newMountWebhook(logger *log.Logger, opts *mountWebhookOptions) (*mountWebhook, error) {
+	if opts.URL == "" {
+		return nil, fmt.Errorf("url of mount webhook is required")
+	}
+
+	timeout := 5 * time.Second
+	if opts.Timeout != "" {
+		var err error
+		timeout, err = time.ParseDuration(opts.Timeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timeout of mount webhook: %v", err)
+		}
+	}
+
+	return &mountWebhook{
+		logger:     logger,
+		url:        opts.URL,
+		failClosed: opts.FailClosed,
+		client:     &http.Client{Timeout: timeout},
+	}, nil
+}
+
+// admit returns an error if the webhook denies mounting volume name for id
+func (w *mountWebhook) admit(name string, id string) error {
+	response, err := w.call(name, id)
+	if err != nil {
+		if w.failClosed {
+			return fmt.Errorf("mount of volume %s denied: webhook failed: %v", name, err)
+		}
+
+		w.logger.Warningf("mount webhook failed, allow mount of volume %s for %s: %v", name, id, err)
+		return nil
+	}
+
+	if !response.Allowed {
+		return fmt.Errorf("mount of volume %s denied by webhook: %s", name, response.Reason)
+	}
+
+	return nil
+}
+
+func (w *mountWebhook) call(name string, id string) (*mountAdmissionResponse, error) {
+	body, err := json.Marshal(&mountAdmissionRequest{Name: name, ID: id})
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := w.client.Post(w.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	response := &mountAdmissionResponse{}
+	err = json.NewDecoder(resp.Body).Decode(response)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode response: %v", err)
+	}
+
+	return response, nil
+}
@@ -0,0 +1,294 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	// defaultNameMax is the file name length limit of most filesystems
+	defaultNameMax = 255
+	// PathMax is the path length limit of linux, including the terminating null byte
+	PathMax = 4096
+)
+
+// NameMax returns the file name length limit of the filesystem containing path.
+func NameMax(path string) int {
+	stat := syscall.Statfs_t{}
+	err := syscall.Statfs(path, &stat)
+	if err != nil || stat.Namelen <= 0 {
+		return defaultNameMax
+	}
+	return int(stat.Namelen)
+}
+
+// FilesystemID returns the filesystem id of the filesystem containing path.
+func FilesystemID(path string) (string, error) {
+	stat := syscall.Statfs_t{}
+	err := syscall.Statfs(path, &stat)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%08x%08x", uint32(stat.Fsid.X__val[0]), uint32(stat.Fsid.X__val[1])), nil
+}
+
+// DiskUsage returns the bytes used by the files under path, and the total and free bytes of the filesystem containing it.
+// Files removed while walking are skipped, and the walk stops once ctx is done.
+func DiskUsage(ctx context.Context, path string) (used uint64, total uint64, free uint64, err error) {
+	stat := syscall.Statfs_t{}
+	err = syscall.Statfs(path, &stat)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	total = stat.Blocks * uint64(stat.Bsize)
+	free = stat.Bavail * uint64(stat.Bsize)
+
+	// The data of an imported volume is a link to the adopted directory
+	root, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return 0, total, free, err
+	}
+
+	err = filepath.WalkDir(root, func(filePath string, entry fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		used += uint64(info.Size())
+		return nil
+	})
+
+	return used, total, free, err
+}
+
+// CopyTree copies the directory tree at srcPath into the existing directory dstPath until ctx is done. File contents
+// are streamed, permissions and symlinks are kept and so is the ownership when permitted. Entries removed during the
+// copy are skipped, and so are special files.
+func CopyTree(ctx context.Context, srcPath string, dstPath string) error {
+	root, err := filepath.EvalSymlinks(srcPath)
+	if err != nil {
+		return err
+	}
+
+	// Directory permissions are applied once their content is copied, so read-only directories can be filled
+	type directory struct {
+		path string
+		mode fs.FileMode
+	}
+	directories := []directory{}
+
+	err = filepath.WalkDir(root, func(filePath string, entry fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		info, err := entry.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		relPath, err := filepath.Rel(root, filePath)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dstPath, relPath)
+
+		switch {
+		case entry.IsDir():
+			if relPath != "." {
+				err = os.Mkdir(target, 0700)
+			}
+			directories = append(directories, directory{path: target, mode: info.Mode()})
+		case entry.Type()&fs.ModeSymlink != 0:
+			var link string
+			link, err = os.Readlink(filePath)
+			if err == nil {
+				err = os.Symlink(link, target)
+			}
+		case entry.Type().IsRegular():
+			err = copyFile(ctx, filePath, target)
+			if err == nil {
+				err = os.Chmod(target, info.Mode())
+			}
+		default:
+			return nil
+		}
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+			// Only root can give files away, other users keep the files as their own
+			_ = os.Lchown(target, int(stat.Uid), int(stat.Gid))
+			if entry.Type().IsRegular() {
+				// Changing the owner clears the setuid and setgid bits
+				return os.Chmod(target, info.Mode())
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for i := len(directories) - 1; i >= 0; i-- {
+		err = os.Chmod(directories[i].path, directories[i].mode)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyFile streams the content of srcPath into the new file dstPath until ctx is done
+func copyFile(ctx context.Context, srcPath string, dstPath string) error {
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(dstPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(dst, &contextReader{ctx: ctx, reader: src})
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// contextReader fails reads once ctx is done, so a copy of a large file can be stopped midway
+type contextReader struct {
+	ctx    context.Context
+	reader io.Reader
+}
+
+func (c *contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.reader.Read(p)
+}
+
+// maxReportedEntries is the number of remaining entries listed when a removal finally fails
+const maxReportedEntries = 10
+
+// RemoveAllWithRetry removes path like os.RemoveAll, making up to attempts attempts on errors that are transient
+// on network filesystems. When the removal finally fails, the error lists what remained.
+func RemoveAllWithRetry(path string, attempts int, interval time.Duration) error {
+	err := os.RemoveAll(path)
+	for attempt := 1; attempt < attempts && err != nil && isTransientRemoveError(err); attempt++ {
+		time.Sleep(interval)
+		err = os.RemoveAll(path)
+	}
+	if err == nil {
+		return nil
+	}
+
+	return fmt.Errorf("%w, remaining: %s", err, remainingEntries(path))
+}
+
+// isTransientRemoveError reports whether err may go away when the removal is retried, such as a stale file handle,
+// a busy file or a directory that still holds a silly renamed .nfs file
+func isTransientRemoveError(err error) bool {
+	return errors.Is(err, syscall.ESTALE) || errors.Is(err, syscall.EBUSY) || errors.Is(err, syscall.ENOTEMPTY)
+}
+
+// remainingEntries describes the entries left under path
+func remainingEntries(path string) string {
+	entries := []string{}
+	count := 0
+	_ = filepath.WalkDir(path, func(entryPath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+
+		count++
+		if len(entries) < maxReportedEntries {
+			entries = append(entries, entryPath)
+		}
+		return nil
+	})
+
+	if count == 0 {
+		return "none"
+	}
+	if count > len(entries) {
+		return fmt.Sprintf("%s and %d more", strings.Join(entries, ", "), count-len(entries))
+	}
+	return strings.Join(entries, ", ")
+}
+
+// WriteFileAtomic replaces the file at filePath with the content written by write through a synced temporary file and
+// a rename, so readers and a crash see the old or new content only
+func WriteFileAtomic(filePath string, write func(file *os.File) error) error {
+	tmpPath := filePath + ".tmp"
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpPath)
+
+	err = write(file)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(tmpPath, filePath)
+	if err != nil {
+		return err
+	}
+
+	// Persist the rename itself, best effort as not every filesystem supports syncing a directory
+	if dir, err := os.Open(path.Dir(filePath)); err == nil {
+		_ = dir.Sync()
+		dir.Close()
+	}
+	return nil
+}
@@ -0,0 +1,187 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRemoveAllWithRetry(t *testing.T) {
+	root := path.Join(t.TempDir(), "volume")
+	err := os.MkdirAll(path.Join(root, "_data", "dir"), 0755)
+	if err != nil {
+		t.Fatalf("got error when create directories: %v", err)
+	}
+
+	err = RemoveAllWithRetry(root, 3, time.Millisecond)
+	if err != nil {
+		t.Fatalf("got error when remove %s: %v", root, err)
+	}
+	_, err = os.Stat(root)
+	if !os.IsNotExist(err) {
+		t.Errorf("expected %s to be removed, got %v", root, err)
+	}
+}
+
+func TestIsTransientRemoveError(t *testing.T) {
+	for _, errno := range []syscall.Errno{syscall.ESTALE, syscall.EBUSY, syscall.ENOTEMPTY} {
+		if !isTransientRemoveError(&os.PathError{Op: "unlinkat", Path: "/mock", Err: errno}) {
+			t.Errorf("expected %v to be transient", errno)
+		}
+	}
+	if isTransientRemoveError(&os.PathError{Op: "unlinkat", Path: "/mock", Err: syscall.EACCES}) {
+		t.Errorf("expected %v not to be transient", syscall.EACCES)
+	}
+}
+
+func TestRemainingEntries(t *testing.T) {
+	root := t.TempDir()
+	if remaining := remainingEntries(path.Join(root, "missing")); remaining != "none" {
+		t.Errorf("expected none for missing path, got %s", remaining)
+	}
+
+	for i := 0; i < maxReportedEntries+2; i++ {
+		err := os.WriteFile(path.Join(root, fmt.Sprintf("file-%02d", i)), nil, 0644)
+		if err != nil {
+			t.Fatalf("got error when write file: %v", err)
+		}
+	}
+	remaining := remainingEntries(root)
+	if !strings.HasSuffix(remaining, "and 3 more") {
+		t.Errorf("expected remaining entries to be truncated, got %s", remaining)
+	}
+}
+
+func TestDiskUsage(t *testing.T) {
+	root := t.TempDir()
+	err := os.MkdirAll(path.Join(root, "dir"), 0755)
+	if err != nil {
+		t.Fatalf("got error when create directories: %v", err)
+	}
+	for name, size := range map[string]int{"file": 1000, "dir/file": 24} {
+		err = os.WriteFile(path.Join(root, name), make([]byte, size), 0644)
+		if err != nil {
+			t.Fatalf("got error when write %s: %v", name, err)
+		}
+	}
+	err = os.Symlink("file", path.Join(root, "link"))
+	if err != nil {
+		t.Fatalf("got error when create symlink: %v", err)
+	}
+
+	used, total, free, err := DiskUsage(context.Background(), root)
+	if err != nil {
+		t.Fatalf("got error when get disk usage of %s: %v", root, err)
+	}
+	if used != 1024 {
+		t.Errorf("expected 1024 bytes used, got %d", used)
+	}
+	if total == 0 || free > total {
+		t.Errorf("expected free bytes %d within total bytes %d", free, total)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, _, _, err = DiskUsage(ctx, root)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected %v when get disk usage with canceled context, got %v", context.Canceled, err)
+	}
+}
+
+func TestCopyTree(t *testing.T) {
+	src := t.TempDir()
+	err := os.MkdirAll(path.Join(src, "dir"), 0755)
+	if err != nil {
+		t.Fatalf("got error when create directories: %v", err)
+	}
+	err = os.WriteFile(path.Join(src, "dir", "file"), []byte("data"), 0640)
+	if err != nil {
+		t.Fatalf("got error when write file: %v", err)
+	}
+	err = os.Symlink("dir/file", path.Join(src, "link"))
+	if err != nil {
+		t.Fatalf("got error when create symlink: %v", err)
+	}
+	err = os.Chmod(path.Join(src, "dir"), 0500)
+	if err != nil {
+		t.Fatalf("got error when chmod directory: %v", err)
+	}
+	defer os.Chmod(path.Join(src, "dir"), 0755)
+
+	dst := t.TempDir()
+	err = CopyTree(context.Background(), src, dst)
+	if err != nil {
+		t.Fatalf("got error when copy tree: %v", err)
+	}
+	defer os.Chmod(path.Join(dst, "dir"), 0755)
+
+	content, err := os.ReadFile(path.Join(dst, "dir", "file"))
+	if err != nil || string(content) != "data" {
+		t.Errorf("expected copied file content data, got %q, %v", string(content), err)
+	}
+	info, err := os.Stat(path.Join(dst, "dir", "file"))
+	if err != nil || info.Mode().Perm() != 0640 {
+		t.Errorf("expected copied file mode 0640, got %v, %v", info, err)
+	}
+	info, err = os.Stat(path.Join(dst, "dir"))
+	if err != nil || info.Mode().Perm() != 0500 {
+		t.Errorf("expected copied directory mode 0500, got %v, %v", info, err)
+	}
+	link, err := os.Readlink(path.Join(dst, "link"))
+	if err != nil || link != "dir/file" {
+		t.Errorf("expected copied symlink to dir/file, got %s, %v", link, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = CopyTree(ctx, src, t.TempDir())
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected canceled copy, got %v", err)
+	}
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	filePath := path.Join(t.TempDir(), "file")
+	err := os.WriteFile(filePath, []byte("old"), 0644)
+	if err != nil {
+		t.Fatalf("got error when write file: %v", err)
+	}
+
+	// A failed write keeps the old content and leaves no temporary file
+	err = WriteFileAtomic(filePath, func(file *os.File) error {
+		_, err := file.WriteString("partial")
+		if err != nil {
+			return err
+		}
+		return errors.New("write failed")
+	})
+	if err == nil {
+		t.Errorf("expected error from failed write")
+	}
+	content, err := os.ReadFile(filePath)
+	if err != nil || string(content) != "old" {
+		t.Errorf("expected old content kept, got %q, %v", content, err)
+	}
+	_, err = os.Stat(filePath + ".tmp")
+	if !os.IsNotExist(err) {
+		t.Errorf("expected temporary file removed, got %v", err)
+	}
+
+	err = WriteFileAtomic(filePath, func(file *os.File) error {
+		_, err := file.WriteString("new")
+		return err
+	})
+	if err != nil {
+		t.Fatalf("got error when write file atomically: %v", err)
+	}
+	content, err = os.ReadFile(filePath)
+	if err != nil || string(content) != "new" {
+		t.Errorf("expected new content, got %q, %v", content, err)
+	}
+}
@@ -1,9 +1,13 @@
 package utils
 
 import (
+	"context"
 	"fmt"
+	"os"
 	"os/exec"
+	"slices"
 	"strings"
+	"syscall"
 
 	"github.com/moby/sys/mountinfo"
 )
@@ -22,13 +26,13 @@ func Bind(sourcePath string, directoryPath string, mountOptions []string) error
 	return nil
 }
 
-// MountNFS mounts an NFS share to a local path.
-func MountNFS(address string, remotePath string, localPath string, mountOptions []string) error {
+// MountNFS mounts an NFS share to a local path, killing the mount command when ctx is done.
+func MountNFS(ctx context.Context, address string, remotePath string, localPath string, mountOptions []string) error {
 	if len(mountOptions) == 0 {
 		mountOptions = []string{"defaults"}
 	}
 
-	cmd := exec.Command("mount", "-t", "nfs", "-o", strings.Join(mountOptions, ","), fmt.Sprintf("%s:%s", address, remotePath), localPath)
+	cmd := exec.CommandContext(ctx, "mount", "-t", "nfs", "-o", strings.Join(mountOptions, ","), fmt.Sprintf("%s:%s", address, remotePath), localPath)
 	output, err := cmd.CombinedOutput()
 	if err != nil {
 		return fmt.Errorf("mount failed: %v, output: %s", err, string(output))
@@ -36,6 +40,128 @@ func MountNFS(address string, remotePath string, localPath string, mountOptions
 	return nil
 }
 
+// MountCIFS mounts a CIFS share to a local path.
+// The username and password are handed to mount.cifs in a temporary root-only credentials file removed after the mount,
+// so they never show up in the process list or in the returned error.
+func MountCIFS(address string, remotePath string, localPath string, mountOptions []string, username string, password string) error {
+	if len(mountOptions) == 0 {
+		mountOptions = []string{"defaults"}
+	}
+
+	if username != "" || password != "" {
+		credentialsFile, err := writeCIFSCredentials(username, password)
+		if err != nil {
+			return fmt.Errorf("failed to write credentials file: %v", err)
+		}
+		defer os.Remove(credentialsFile)
+
+		mountOptions = append(slices.Clone(mountOptions), "credentials="+credentialsFile)
+	}
+
+	cmd := exec.Command("mount", "-t", "cifs", "-o", strings.Join(mountOptions, ","), fmt.Sprintf("//%s%s", address, remotePath), localPath)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("mount failed: %v, output: %s", err, string(output))
+	}
+	return nil
+}
+
+// writeCIFSCredentials writes a mount.cifs credentials file only readable by its owner and returns its path.
+// A line break would add lines to the file, so values containing one are rejected.
+func writeCIFSCredentials(username string, password string) (string, error) {
+	if strings.ContainsAny(username, "\r\n") {
+		return "", fmt.Errorf("username must not contain line breaks")
+	}
+	if strings.ContainsAny(password, "\r\n") {
+		return "", fmt.Errorf("password must not contain line breaks")
+	}
+
+	file, err := os.CreateTemp("", "cifs-credentials-")
+	if err != nil {
+		return "", err
+	}
+
+	_, err = fmt.Fprintf(file, "username=%s\npassword=%s\n", username, password)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+
+	return file.Name(), nil
+}
+
+// mountOptionKeys maps mount options to the setting they configure when it differs from the option name,
+// so that conflicting options such as ro and rw replace each other
+var mountOptionKeys = map[string]string{
+	"rw":      "ro",
+	"ro":      "ro",
+	"sync":    "sync",
+	"async":   "sync",
+	"hard":    "hard",
+	"soft":    "hard",
+	"softerr": "hard",
+	"fg":      "bg",
+	"bg":      "bg",
+	"tcp":     "proto",
+	"udp":     "proto",
+	"nfsvers": "vers",
+	"vers":    "vers",
+}
+
+// mountOptionKey returns the setting configured by a mount option, treating noX as the negation of X
+func mountOptionKey(option string) string {
+	key, _, _ := strings.Cut(option, "=")
+	if mapped, ok := mountOptionKeys[key]; ok {
+		return mapped
+	}
+	return strings.TrimPrefix(key, "no")
+}
+
+// MergeMountOptions merges layers of mount options, from lowest to highest precedence.
+// Options configuring the same setting are deduplicated and the last one wins,
+// keeping the position where the setting first appeared.
+func MergeMountOptions(layers ...[]string) []string {
+	merged := []string{}
+	positions := map[string]int{}
+	for _, layer := range layers {
+		for _, option := range layer {
+			key := mountOptionKey(option)
+			if position, ok := positions[key]; ok {
+				merged[position] = option
+				continue
+			}
+
+			positions[key] = len(merged)
+			merged = append(merged, option)
+		}
+	}
+	return merged
+}
+
+// NFSVersion returns the NFS version requested by mount options, or empty if it is negotiated.
+func NFSVersion(mountOptions []string) string {
+	version := ""
+	for _, option := range mountOptions {
+		key, value, found := strings.Cut(option, "=")
+		if found && (key == "nfsvers" || key == "vers") {
+			version = value
+		}
+	}
+	return version
+}
+
+// UmountLazy detaches a mount from a local path with MNT_DETACH, even while processes still hang on it.
+func UmountLazy(localPath string) error {
+	err := syscall.Unmount(localPath, syscall.MNT_DETACH)
+	if err != nil {
+		return fmt.Errorf("lazy umount failed: %v", err)
+	}
+	return nil
+}
+
 // UmountNFS unmounts an NFS share from a local path.
 func Umount(localPath string) error {
 	cmd := exec.Command("umount", localPath)
@@ -46,6 +172,25 @@ func Umount(localPath string) error {
 	return nil
 }
 
+// NFSServerAddress returns the server address the kernel used for the NFS mount on localPath.
+func NFSServerAddress(localPath string) (string, error) {
+	mounts, err := mountinfo.GetMounts(mountinfo.SingleEntryFilter(localPath))
+	if err != nil {
+		return "", err
+	}
+	if len(mounts) == 0 {
+		return "", fmt.Errorf("%s is not a mount point", localPath)
+	}
+
+	for _, option := range strings.Split(mounts[0].VFSOptions, ",") {
+		key, value, found := strings.Cut(option, "=")
+		if found && key == "addr" {
+			return value, nil
+		}
+	}
+	return "", fmt.Errorf("no server address in mount options of %s", localPath)
+}
+
 // isMounted check if a local path is mount point.
 func IsMounted(path string) (bool, error) {
 	return mountinfo.Mounted(path)
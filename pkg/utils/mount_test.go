@@ -0,0 +1,80 @@
+package utils
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestMergeMountOptions(t *testing.T) {
+	cases := []struct {
+		layers   [][]string
+		expected string
+	}{
+		{
+			layers:   [][]string{{"nfsvers=4", "rw", "rsize=8192"}},
+			expected: "nfsvers=4,rw,rsize=8192",
+		},
+		{
+			layers:   [][]string{{"rsize=8192", "rsize=32768"}},
+			expected: "rsize=32768",
+		},
+		{
+			layers:   [][]string{{"nfsvers=4", "rw", "noatime", "sync"}, {"ro", "atime", "vers=4.1", "async"}},
+			expected: "vers=4.1,ro,atime,async",
+		},
+		{
+			layers:   [][]string{{"tcp", "hard", "fg"}, {"udp"}, {"soft", "bg"}},
+			expected: "udp,soft,bg",
+		},
+		{
+			layers:   [][]string{nil, {"rw"}, {}},
+			expected: "rw",
+		},
+	}
+
+	for _, c := range cases {
+		merged := strings.Join(MergeMountOptions(c.layers...), ",")
+		if merged != c.expected {
+			t.Errorf("expected %s when merge %v, got %s", c.expected, c.layers, merged)
+		}
+	}
+}
+
+func TestWriteCIFSCredentials(t *testing.T) {
+	credentialsFile, err := writeCIFSCredentials("user", "secret-password")
+	if err != nil {
+		t.Fatalf("got error when write credentials file: %v", err)
+	}
+	defer os.Remove(credentialsFile)
+
+	info, err := os.Stat(credentialsFile)
+	if err != nil {
+		t.Fatalf("got error when stat credentials file: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("expected credentials file mode 0600, got %o", info.Mode().Perm())
+	}
+
+	content, err := os.ReadFile(credentialsFile)
+	if err != nil {
+		t.Fatalf("got error when read credentials file: %v", err)
+	}
+	if string(content) != "username=user\npassword=secret-password\n" {
+		t.Errorf("unexpected credentials file content %q", string(content))
+	}
+}
+
+func TestWriteCIFSCredentialsLineBreaks(t *testing.T) {
+	for _, credentials := range [][2]string{
+		{"user\ndomain=OTHER", "secret-password"},
+		{"user", "secret\rpassword"},
+		{"user", "secret-password\ndomain=OTHER"},
+	} {
+		credentialsFile, err := writeCIFSCredentials(credentials[0], credentials[1])
+		if err == nil {
+			os.Remove(credentialsFile)
+			t.Errorf("expected error when write credentials %q", credentials)
+		}
+	}
+}
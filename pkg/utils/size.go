@@ -0,0 +1,41 @@
+package utils
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// sizePattern matches a number of bytes with an optional binary unit, such as 512, 10G, 10GB, 1Ti or 1TiB
+var sizePattern = regexp.MustCompile(`^([0-9]+)(?:([KMGTP])I?)?B?$`)
+
+// sizeUnits are the multipliers of the units accepted by ParseSize
+var sizeUnits = map[string]uint64{
+	"":  1,
+	"K": 1 << 10,
+	"M": 1 << 20,
+	"G": 1 << 30,
+	"T": 1 << 40,
+	"P": 1 << 50,
+}
+
+// ParseSize parses a size in bytes with an optional case insensitive binary unit K, M, G, T or P
+func ParseSize(value string) (uint64, error) {
+	match := sizePattern.FindStringSubmatch(strings.ToUpper(value))
+	if match == nil {
+		return 0, fmt.Errorf("size %s is malformed, expected a number of bytes with an optional unit K, M, G, T or P", value)
+	}
+
+	number, err := strconv.ParseUint(match[1], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("size %s is malformed: %v", value, err)
+	}
+	unit := sizeUnits[match[2]]
+	if number > math.MaxUint64/unit {
+		return 0, fmt.Errorf("size %s is too large", value)
+	}
+
+	return number * unit, nil
+}
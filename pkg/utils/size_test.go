@@ -0,0 +1,37 @@
+package utils
+
+import "testing"
+
+func TestParseSize(t *testing.T) {
+	cases := []struct {
+		value    string
+		expected uint64
+	}{
+		{"512", 512},
+		{"512B", 512},
+		{"10K", 10 << 10},
+		{"10m", 10 << 20},
+		{"10G", 10 << 30},
+		{"10GB", 10 << 30},
+		{"1Ti", 1 << 40},
+		{"1TiB", 1 << 40},
+		{"2P", 2 << 50},
+	}
+	for _, c := range cases {
+		size, err := ParseSize(c.value)
+		if err != nil {
+			t.Errorf("got error when parse size %s: %v", c.value, err)
+			continue
+		}
+		if size != c.expected {
+			t.Errorf("expected size %d for %s, got %d", c.expected, c.value, size)
+		}
+	}
+
+	for _, value := range []string{"", "G", "-1G", "1.5G", "10X", "10 G", "10I", "10GiBs", "16777216T"} {
+		_, err := ParseSize(value)
+		if err == nil {
+			t.Errorf("expected error when parse size %q", value)
+		}
+	}
+}